@@ -0,0 +1,80 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/scbunn/mdbload/pkg/report"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare [baseline report] [current report]",
+	Short: "Compare a JSON report with a baseline",
+	Long: `Compare the throughput, latency percentiles and error rates of every operation, and the document sizes, of two
+JSON reports written by 'start --report-format json'.
+
+Changes beyond the tolerances are flagged.  The exit status is 2 if any operation regressed or is missing from the
+current report.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		tolerances := report.Tolerances{}
+		tolerances.Latency, _ = cmd.Flags().GetFloat64("latency-tolerance")
+		tolerances.Throughput, _ = cmd.Flags().GetFloat64("throughput-tolerance")
+		tolerances.ErrorRate, _ = cmd.Flags().GetFloat64("error-rate-tolerance")
+		tolerances.DocumentSize, _ = cmd.Flags().GetFloat64("size-tolerance")
+
+		baseline := readReport(args[0])
+		current := readReport(args[1])
+		c := report.Compare(baseline, current, tolerances)
+		if err := c.Write(os.Stdout, format); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if c.Regressed() {
+			os.Exit(exitRegression)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().String("format", report.FormatText, "output format (text|markdown|json)")
+	compareCmd.Flags().Float64("latency-tolerance", 0.1, "allowed relative change of latency percentiles (0.1 is 10%)")
+	compareCmd.Flags().Float64("throughput-tolerance", 0.1, "allowed relative change of throughput")
+	compareCmd.Flags().Float64("error-rate-tolerance", 0.001, "allowed absolute change of error rates (0.001 is 0.1 percentage points)")
+	compareCmd.Flags().Float64("size-tolerance", 0.05, "allowed relative change of document sizes")
+}
+
+// read a JSON report from a file
+func readReport(file string) *report.Report {
+	l := log.WithField("file", file)
+	f, err := os.Open(file)
+	if err != nil {
+		l.WithField("error", err).Fatal("could not open the report")
+	}
+	defer f.Close()
+	r, err := report.ReadReport(f)
+	if err != nil {
+		l.WithField("error", err).Fatal("could not read the report")
+	}
+	return r
+}
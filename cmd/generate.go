@@ -0,0 +1,108 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/scbunn/mdbload/pkg/generator"
+	"github.com/scbunn/mdbload/pkg/workload"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// generateCmd represents the generate command
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Render documents from templates into a corpus file",
+	Long: `Render documents from the configured templates into a corpus file that 'start --corpus' inserts instead of
+rendering templates during the test.
+
+The format is taken from the extension of the output file: .bson writes concatenated BSON documents (the layout
+mongodump writes) and anything else writes one canonical extended JSON document per line.  'start --corpus' reads the
+format back from the extension, so --format must agree with it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		count, _ := cmd.Flags().GetInt64("count")
+		out, _ := cmd.Flags().GetString("out")
+		format, _ := cmd.Flags().GetString("format")
+		renderers, _ := cmd.Flags().GetInt("generators")
+		if format == "" {
+			format = generator.CorpusFormat(out)
+		}
+		l := log.WithFields(log.Fields{
+			"file":   out,
+			"format": format,
+			"count":  count,
+		})
+		// the corpus is read back in the format of its extension
+		if format != generator.CorpusFormat(out) {
+			l.WithField("extension", generator.CorpusFormat(out)).Fatal("the format does not match the extension of the corpus")
+		}
+
+		f, err := os.Create(out)
+		if err != nil {
+			l.WithField("error", err).Fatal("could not create the corpus")
+		}
+		defer f.Close()
+		w, err := generator.NewCorpusWriter(f, format)
+		if err != nil {
+			l.WithField("error", err).Fatal("invalid corpus format")
+		}
+
+		names := viper.GetString("templates.name")
+		mix, err := workload.ParseMix(names)
+		if err != nil {
+			l.WithField("error", err).Fatal("invalid template list")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g := generator.Generator{
+			Name:      "corpus",
+			Templates: parseTemplates(),
+			Mix:       mix,
+			Types:     templateTypes(),
+			Renderers: renderers,
+			Seed:      viper.GetInt64("random.seed"),
+			Buffer:    1024,
+		}
+		documents := g.Start(ctx)
+
+		start := time.Now()
+		for i := int64(0); i < count; i++ {
+			if err := w.Write(<-documents); err != nil {
+				l.WithField("error", err).Fatal("could not write a document")
+			}
+		}
+		cancel()
+		if err := w.Flush(); err != nil {
+			l.WithField("error", err).Fatal("could not write the corpus")
+		}
+		fmt.Printf("wrote %d documents from %s to %s in %s\n", count, names, out, time.Since(start).Round(time.Millisecond))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateCmd)
+	generateCmd.Flags().Int64("count", 1000, "number of documents to generate")
+	generateCmd.Flags().String("out", "", "corpus file to write")
+	generateCmd.Flags().String("format", "", "corpus format (bson|jsonl); must match the file extension")
+	generateCmd.Flags().Int("generators", runtime.NumCPU(), "number of goroutines rendering documents")
+	generateCmd.MarkFlagRequired("out")
+}
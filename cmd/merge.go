@@ -0,0 +1,71 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scbunn/mdbload/pkg/report"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// mergeCmd represents the merge command
+var mergeCmd = &cobra.Command{
+	Use:   "merge [histogram file]...",
+	Short: "Merge the histograms of several instances into one report",
+	Long: `Merge the latency histograms written by 'start --histogram-file' on several instances into a single report.
+
+Percentiles are computed from the merged histograms so they are exact for the whole distributed run, unlike
+averaging the percentiles of each instance.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		out, _ := cmd.Flags().GetString("out")
+
+		merged := report.NewRecorder()
+		instances := []string{}
+		for _, file := range args {
+			l := log.WithField("file", file)
+			f, err := os.Open(file)
+			if err != nil {
+				l.WithField("error", err).Fatal("could not open the histogram file")
+			}
+			r, err := report.ReadHistograms(f)
+			f.Close()
+			if err != nil {
+				l.WithField("error", err).Fatal("could not read the histogram file")
+			}
+			merged.Merge(r)
+			instances = append(instances, file)
+			l.Info("merged histograms")
+		}
+
+		r := report.New(merged, VERSION, strings.Join(instances, ","))
+		if err := writeReport(r, format, out); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+	mergeCmd.Flags().String("format", report.FormatText, "format of the merged report (text|json|csv)")
+	mergeCmd.Flags().String("out", "", "write the merged report to a file instead of stdout")
+}
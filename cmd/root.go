@@ -24,6 +24,8 @@ import (
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/onrik/logrus/filename"
+	"github.com/scbunn/mdbload/pkg/mongo"
+	"github.com/scbunn/mdbload/pkg/queue"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -76,6 +78,18 @@ func init() {
 	rootCmd.PersistentFlags().Duration("mongodb-socket-timeout", 1*time.Second, "MongoDB operation timeout")
 	rootCmd.PersistentFlags().Uint16("mongodb-connection-pool-size", 100, "Size of the mongodb connection pool")
 
+	// Templates
+	rootCmd.PersistentFlags().String("template-dir", ".", "Directory where document templates are located")
+	rootCmd.PersistentFlags().String("template-name", "example.template", "Name of the template, or weighted list of templates (name:weight,...), to use for generation")
+
+	// Queue
+	rootCmd.PersistentFlags().Bool("enable-redis", false, "Enable redis document queue")
+	rootCmd.PersistentFlags().String("redis-server", "127.0.0.1:6379", "Redis server and port")
+	rootCmd.PersistentFlags().String("redis-key", queue.DefaultRedisKey, "Redis key of the document queue")
+
+	// Reproducible runs
+	rootCmd.PersistentFlags().Int64("seed", 0, "seed document generation and operation selection to make runs reproducible (0 for random)")
+
 	// logging
 	rootCmd.PersistentFlags().Bool("enable-logging", false, "enable output logging")
 	rootCmd.PersistentFlags().Bool("logging-source", false, "enable source file logging field")
@@ -92,6 +106,12 @@ func init() {
 	viper.BindPFlag("mongodb.socketTimeout", rootCmd.PersistentFlags().Lookup("mongodb-socket-timeout"))
 	viper.BindPFlag("mongodb.serverConnectTimeout", rootCmd.PersistentFlags().Lookup("mongodb-server-selection-timeout"))
 	viper.BindPFlag("mongodb.connectTimeout", rootCmd.PersistentFlags().Lookup("mongodb-connection-timeout"))
+	viper.BindPFlag("templates.directory", rootCmd.PersistentFlags().Lookup("template-dir"))
+	viper.BindPFlag("templates.name", rootCmd.PersistentFlags().Lookup("template-name"))
+	viper.BindPFlag("queue.redis.enable", rootCmd.PersistentFlags().Lookup("enable-redis"))
+	viper.BindPFlag("queue.redis.server", rootCmd.PersistentFlags().Lookup("redis-server"))
+	viper.BindPFlag("queue.redis.key", rootCmd.PersistentFlags().Lookup("redis-key"))
+	viper.BindPFlag("random.seed", rootCmd.PersistentFlags().Lookup("seed"))
 	viper.BindPFlag("logging.enable", rootCmd.PersistentFlags().Lookup("enable-logging"))
 	viper.BindPFlag("logging.level", rootCmd.PersistentFlags().Lookup("logging-level"))
 	viper.BindPFlag("logging.format", rootCmd.PersistentFlags().Lookup("logging-format"))
@@ -99,6 +119,24 @@ func init() {
 
 }
 
+// mongoOptions builds the mongo options shared by every command from the
+// mongodb configuration
+func mongoOptions() *mongo.MongoLoadOptions {
+	return &mongo.MongoLoadOptions{
+		ConnectionString:     viper.GetString("mongodb.connectionString"),
+		Database:             viper.GetString("mongodb.database"),
+		Collection:           viper.GetString("mongodb.collection"),
+		SocketTimeout:        viper.GetDuration("mongodb.socketTimeout"),
+		ServerConnectTimeout: viper.GetDuration("mongodb.serverConnectTimeout"),
+		ConnectionTimeout:    viper.GetDuration("mongodb.connectTimeout"),
+		ReadPreference:       viper.GetString("mongodb.readPreference"),
+		WriteAcks:            viper.GetInt("mongodb.writeConcern"),
+		EnableJournal:        viper.GetBool("mongodb.writeJournal"),
+		MaxPoolSize:          uint16(viper.GetUint("mongodb.connectionPoolSize")),
+		Version:              VERSION,
+	}
+}
+
 // configureLogging configures a new logrus logger
 func configureLogging() {
 	lvl := viper.GetString("logging.level")
@@ -171,4 +209,8 @@ func initConfig() {
 
 	// configure logging
 	configureLogging()
+
+	if seed := viper.GetInt64("random.seed"); seed != 0 {
+		log.WithField("seed", seed).Info("using a fixed random seed")
+	}
 }
@@ -0,0 +1,114 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/scbunn/mdbload/pkg/mongo"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// setupCmd represents the setup command
+var setupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Prepare the target collection for a load test",
+	Long: `Create the collections, indexes and sharding described by a setup spec.
+
+The spec is read from the 'setup' section of the configuration file or from the file given with --spec.  Setup is
+idempotent; every object is compared with the cluster and only missing or changed objects are created.  Each object
+is printed with the action taken:
+
+  +  created          ~  modified          -  dropped
+  =  unchanged        !  conflict that requires --drop to resolve`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("spec")
+		drop, _ := cmd.Flags().GetBool("drop")
+
+		spec := setupSpec(file)
+		opts := mongoOptions()
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ConnectionTimeout+opts.ServerConnectTimeout)
+		client, err := mongo.Connect(ctx, opts)
+		cancel()
+		if err != nil {
+			log.WithField("error", err).Fatal("could not connect to mongo")
+		}
+		defer client.Disconnect(context.Background())
+
+		changes, err := mongo.Setup(context.Background(), client, spec, drop)
+		conflicts := 0
+		for _, c := range changes {
+			fmt.Println(c)
+			if c.Action == mongo.ChangeConflict {
+				conflicts++
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if conflicts > 0 {
+			fmt.Printf("%d conflicts could not be applied\n", conflicts)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setupCmd)
+	setupCmd.Flags().String("spec", "", "read the setup spec from a file instead of the configuration")
+	setupCmd.Flags().Bool("drop", false, "drop every collection of the spec before creating it")
+}
+
+// setupSpec reads the setup spec and fills in the configured database and
+// collection where the spec leaves them out
+func setupSpec(file string) *mongo.SetupSpec {
+	spec := mongo.SetupSpec{}
+	var err error
+	if file != "" {
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			log.WithFields(log.Fields{
+				"file":  file,
+				"error": err,
+			}).Fatal("could not read the setup spec")
+		}
+		err = v.Unmarshal(&spec)
+	} else {
+		err = viper.UnmarshalKey("setup", &spec)
+	}
+	if err != nil {
+		log.WithField("error", err).Fatal("invalid setup spec")
+	}
+	if len(spec.Collections) == 0 {
+		spec.Collections = []mongo.CollectionSpec{{}}
+	}
+	for i := range spec.Collections {
+		c := &spec.Collections[i]
+		if c.Database == "" {
+			c.Database = viper.GetString("mongodb.database")
+		}
+		if c.Name == "" {
+			c.Name = viper.GetString("mongodb.collection")
+		}
+	}
+	return &spec
+}
@@ -19,31 +19,29 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/scbunn/docgen"
+	"github.com/scbunn/mdbload/pkg/generator"
 	"github.com/scbunn/mdbload/pkg/mongo"
 	"github.com/scbunn/mdbload/pkg/queue"
+	"github.com/scbunn/mdbload/pkg/report"
 	"github.com/scbunn/mdbload/pkg/telemetry"
+	"github.com/scbunn/mdbload/pkg/workload"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var (
-	templateDuration = prometheus.NewSummary(
-		prometheus.SummaryOpts{
-			Namespace: "mdbload",
-			Name:      "generate_template_duration_seconds",
-			Help:      "The duration to generate a template",
-		},
-	)
-)
-
 // prometheusOptions builds a new telemetry.PrometheusOptions object
 func prometheusOptions() *telemetry.PrometheusOptions {
 	options := telemetry.PrometheusOptions{
@@ -57,6 +55,9 @@ type TelemetryData struct {
 	registry               *prometheus.Registry
 	pushGatewayExitChannel chan bool
 	prometheusOptions      *telemetry.PrometheusOptions
+	readiness              *telemetry.Readiness
+	server                 *telemetry.Server
+	latencyBuckets         []float64
 }
 
 func configureTelemetry(wg *sync.WaitGroup) (*TelemetryData, bool) {
@@ -64,9 +65,19 @@ func configureTelemetry(wg *sync.WaitGroup) (*TelemetryData, bool) {
 		registry:               prometheus.NewRegistry(),
 		pushGatewayExitChannel: make(chan bool),
 		prometheusOptions:      prometheusOptions(),
+		readiness:              new(telemetry.Readiness),
+	}
+	td.readiness.Require("mongo")
+
+	buckets, err := telemetry.ParseBuckets(viper.GetString("telemetry.latencyBuckets"))
+	if err != nil {
+		log.WithField("error", err).Error("invalid latency buckets")
+		return &td, false
 	}
+	td.latencyBuckets = buckets
 
-	td.registry.MustRegister(templateDuration)
+	td.registry.MustRegister(prometheus.NewGoCollector())
+	generator.RegisterMetrics(td.registry)
 	metrics := telemetry.Prometheus{
 		Options:  td.prometheusOptions,
 		Registry: td.registry,
@@ -77,10 +88,21 @@ func configureTelemetry(wg *sync.WaitGroup) (*TelemetryData, bool) {
 		go metrics.PushMetrics(wg, td.pushGatewayExitChannel)
 	}
 
+	if listen := viper.GetString("telemetry.listen"); listen != "" {
+		td.server = &telemetry.Server{
+			Listen:    listen,
+			Registry:  td.registry,
+			Readiness: td.readiness,
+		}
+		td.server.Start()
+	}
+
 	return &td, true
 }
 
-func createQueue(registry *prometheus.Registry) *queue.Queue {
+// createQueue creates the document queue.  sampled asks for a queue.Store so
+// reads can sample the written ids.
+func createQueue(registry *prometheus.Registry, buckets []float64, sampled bool) *queue.Queue {
 	var q queue.Queue
 	var queueType string
 	l := log.WithFields(log.Fields{
@@ -90,18 +112,30 @@ func createQueue(registry *prometheus.Registry) *queue.Queue {
 	if viper.GetBool("queue.redis.enable") {
 		// TODO: Redis Options
 		rq := queue.RedisQueue{
-			Server:   viper.GetString("queue.redis.server"),
-			Registry: registry,
+			Server:         viper.GetString("queue.redis.server"),
+			Key:            viper.GetString("queue.redis.key"),
+			Registry:       registry,
+			LatencyBuckets: buckets,
 		}
 		rq.Init()
 		q = &rq
 		queueType = "Redis"
 		l = l.WithFields(log.Fields{
 			"server": viper.GetString("queue.redis.server"),
+			"key":    viper.GetString("queue.redis.key"),
 		})
+	} else if sampled {
+		ms := queue.MemoryStore{
+			Registry:       registry,
+			LatencyBuckets: buckets,
+		}
+		ms.Init()
+		q = &ms
+		queueType = "Memory Store"
 	} else {
 		mq := queue.MemoryQueue{
-			Registry: registry,
+			Registry:       registry,
+			LatencyBuckets: buckets,
 		}
 		mq.Init()
 		q = &mq
@@ -113,88 +147,633 @@ func createQueue(registry *prometheus.Registry) *queue.Queue {
 	return &q
 }
 
-func createLoadTester(registry *prometheus.Registry, q *queue.Queue) (*mongo.MongoLoad, func()) {
+func createLoadTester(registry *prometheus.Registry, buckets []float64, q *queue.Queue, recorder *report.Recorder, duration time.Duration) (*mongo.MongoLoad, func()) {
 	// Create a new context
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 
-	options := mongo.MongoLoadOptions{
-		ConnectionString:     viper.GetString("mongodb.connectionString"),
-		Database:             viper.GetString("mongodb.database"),
-		Collection:           viper.GetString("mongodb.collection"),
-		TestDuration:         viper.GetDuration("duration"),
-		SocketTimeout:        viper.GetDuration("mongodb.socketTimeout"),
-		ServerConnectTimeout: viper.GetDuration("mongodb.serverConnectTimeout"),
-		ConnectionTimeout:    viper.GetDuration("mongodb.connectTimeout"),
-		ReadPreference:       viper.GetString("mongodb.readPreference"),
-		WriteAcks:            viper.GetInt("mongodb.writeConcern"),
-		EnableJournal:        viper.GetBool("mongodb.writeJournal"),
-		MaxPoolSize:          uint16(viper.GetUint("mongodb.connectionPoolSize")),
-		Version:              VERSION,
-		Queue:                q,
-		PrometheusRegistry:   registry,
-	}
+	options := mongoOptions()
+	options.TestDuration = duration
+	options.Queue = q
+	options.PrometheusRegistry = registry
+	options.LatencyBuckets = buckets
+	options.Recorder = recorder
 	mdb := new(mongo.MongoLoad)
-	if err := mdb.Init(ctx, &options); err != nil {
+	if err := mdb.Init(ctx, options); err != nil {
 		log.Fatal(err)
 	}
 	return mdb, cancel
 }
 
-func generateDocuments() chan interface{} {
-	documentChannel := make(chan interface{}, 1024)
+// parse every template in the configured template directory
+func parseTemplates() *template.Template {
 	templateDirectory := viper.GetString("templates.directory")
-	templateName := viper.GetString("templates.name")
+	templates, err := docgen.ParseTemplates(templateDirectory)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": templateDirectory,
+			"error":     err,
+		}).Fatal("Could not start document generation")
+	}
+	return templates
+}
+
+// generateDocuments starts rendering documents from a weighted list of
+// templates (name:weight,...).  A single template name without a weight is
+// a list of one.  name labels the metrics of the generator.
+func generateDocuments(ctx context.Context, templates *template.Template, name string, templateNames string, readiness *telemetry.Readiness) chan mongo.Document {
 	l := log.WithFields(log.Fields{
-		"directory": templateDirectory,
-		"name":      templateName,
+		"directory": viper.GetString("templates.directory"),
+		"name":      templateNames,
+		"renderers": viper.GetInt("generator.renderers"),
 	})
 
-	templates, err := docgen.ParseTemplates(templateDirectory)
+	mix, err := workload.ParseMix(templateNames)
 	if err != nil {
-		l.WithFields(log.Fields{
-			"error": err,
-		}).Fatal("Could not start document generation")
+		l.WithField("error", err).Fatal("invalid template list")
+	}
+	for _, item := range mix.Items() {
+		if templates.Lookup(item.Name) == nil {
+			l.WithField("template", item.Name).Fatal("template not found")
+		}
+		// fail before the test starts rather than in the middle of it
+		if _, err := generator.Render(templates, item.Name, templateTypes(), nil); err != nil {
+			l.WithFields(log.Fields{
+				"template": item.Name,
+				"error":    err,
+			}).Fatal("invalid template; see 'mdbload template render'")
+		}
 	}
 
 	// Start template generation in a goroutine
 	l.Info("Starting document generation")
-	go createDocumentsFromTemplates(templates, templateName, documentChannel)
-	return documentChannel
+	condition := "template " + templateNames
+	readiness.Require(condition)
+	g := generator.Generator{
+		Name:      name,
+		Templates: templates,
+		Mix:       mix,
+		Types:     templateTypes(),
+		Renderers: viper.GetInt("generator.renderers"),
+		Seed:      viper.GetInt64("random.seed"),
+		Buffer:    viper.GetInt("generator.buffer"),
+		Rendered: func() {
+			readiness.Met(condition)
+		},
+	}
+	return g.Start(ctx)
 }
 
-// create new documents from a template and pump them into the document template channel
-func createDocumentsFromTemplates(templates *template.Template, name string, c chan interface{}) {
-	document := renderDocument(templates, name)
-	for {
-		select {
-		case c <- document:
-			document = renderDocument(templates, name)
+// the configured type hints of template fields
+func templateTypes() generator.TypeHints {
+	types := generator.TypeHints(viper.GetStringMapString("templates.types"))
+	if err := types.Validate(); err != nil {
+		log.WithField("error", err).Fatal("invalid template types")
+	}
+	return types
+}
+
+// parse the configured read key distribution; nil consumes the queue in
+// order
+func keySelector() workload.KeySelector {
+	keys, err := workload.ParseKeySelector(viper.GetString("workload.keys"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"distribution": viper.GetString("workload.keys"),
+			"error":        err,
+		}).Fatal("invalid key distribution")
+	}
+	return keys
+}
+
+// parse the configured workload mix
+func workloadMix() *workload.Mix {
+	mix, err := workload.ParseMix(viper.GetString("workload.mix"))
+	if err == nil {
+		err = mongo.ValidateMix(mix)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"mix":   viper.GetString("workload.mix"),
+			"error": err,
+		}).Fatal("invalid workload mix")
+	}
+	return mix
+}
+
+// load the load profile from the configuration.  nil is returned if no
+// profile is configured.
+func loadProfile(registry *prometheus.Registry) *workload.Profile {
+	if !viper.IsSet("profile.stages") {
+		return nil
+	}
+	profile := workload.Profile{
+		Registry: registry,
+	}
+	if err := viper.UnmarshalKey("profile.stages", &profile.Stages); err != nil {
+		log.WithField("error", err).Fatal("could not read the load profile")
+	}
+	if err := profile.Init(); err != nil {
+		log.WithField("error", err).Fatal("invalid load profile")
+	}
+	log.WithFields(log.Fields{
+		"stages":   len(profile.Stages),
+		"duration": profile.Duration(),
+	}).Info("loaded load profile")
+	return &profile
+}
+
+// the duration of the load test; a profile overrides the configured duration
+func testDuration(profile *workload.Profile) time.Duration {
+	if profile != nil {
+		return profile.Duration()
+	}
+	return viper.GetDuration("duration")
+}
+
+// create a scheduler for rate limited load generation.  nil is returned if
+// neither a target rate nor a rate profile is configured.
+func createScheduler(registry *prometheus.Registry, mix *workload.Mix, profile *workload.Profile) *workload.Scheduler {
+	scheduler := workload.Scheduler{
+		Arrival:  viper.GetString("workload.arrival"),
+		Seed:     viper.GetInt64("random.seed"),
+		Registry: registry,
+	}
+	target := viper.GetString("workload.rate")
+	l := log.WithFields(log.Fields{
+		"rate":    target,
+		"arrival": scheduler.Arrival,
+	})
+	switch {
+	case profile != nil && profile.RateLimited():
+		// the profile rate is shared by the operations of the mix
+		scheduler.Rates = mix
+		scheduler.Profile = profile
+		if target != "" {
+			l.Warn("target rate is ignored in favor of the load profile")
+		}
+	case target != "":
+		rates, err := workload.ParseMix(target)
+		if err == nil {
+			err = mongo.ValidateMix(rates)
+		}
+		if err != nil {
+			l.WithField("error", err).Fatal("invalid target rate")
+		}
+		scheduler.Rates = rates
+	default:
+		return nil
+	}
+	if err := scheduler.Init(); err != nil {
+		l.WithField("error", err).Fatal("could not create the scheduler")
+	}
+	l.Info("created rate limited scheduler")
+	return &scheduler
+}
+
+// build the worker options for a workload, only generating the documents
+// the workload will use
+func workerOptions(ctx context.Context, mix *workload.Mix, scheduler *workload.Scheduler, profile *workload.Profile, readiness *telemetry.Readiness) *mongo.WorkerOptions {
+	opts := mongo.WorkerOptions{
+		Mix:              mix,
+		Scheduler:        scheduler,
+		Seed:             viper.GetInt64("random.seed"),
+		WaitForDocuments: viper.GetBool("generator.wait"),
+	}
+	if profile != nil && !profile.RateLimited() {
+		opts.Profile = profile
+	}
+	operations := mix
+	if scheduler != nil {
+		operations = scheduler.Rates
+	}
+	inserts := operations.Weight(mongo.OperationInsert) > 0 || operations.Weight(mongo.OperationReplace) > 0
+	updates := operations.Weight(mongo.OperationUpdate) > 0
+	if operations.Weight(mongo.OperationBulk) > 0 {
+		opts.Bulk = bulkOptions()
+		inserts = inserts || opts.Bulk.Mix.Weight(mongo.ModelInsert) > 0
+		updates = updates || opts.Bulk.Mix.Weight(mongo.ModelUpdate) > 0
+	}
+	if operations.Weight(mongo.OperationTransaction) > 0 {
+		opts.Transactions = transactionOptions()
+		inserts = inserts || opts.Transactions.Uses(mongo.StepInsert) || opts.Transactions.Uses(mongo.StepReplace)
+		updates = updates || opts.Transactions.Uses(mongo.StepUpdate)
+	}
+	if inserts {
+		opts.Documents = documentSource(ctx, readiness)
+	}
+	if updates {
+		name := viper.GetString("templates.update")
+		if name == "" {
+			log.Fatal("an update template is required when the workload contains updates")
+		}
+		opts.Updates = generateDocuments(ctx, parseTemplates(), "update", name, readiness)
+	}
+	for _, item := range operations.Items() {
+		var file string
+		if name := mongo.QueryName(item.Name); name != "" {
+			file = operationTemplate("queries", name)
+		} else if name := mongo.PipelineName(item.Name); name != "" {
+			file = operationTemplate("aggregations", name)
+		} else {
+			continue
+		}
+		if opts.Queries == nil {
+			opts.Queries = make(map[string]chan mongo.Document)
+			opts.Sampled = make(map[string]bool)
+			opts.Samples = mongo.NewSamples(viper.GetInt("workload.querySamples"), viper.GetInt64("random.seed"))
+		}
+		templates := parseTemplates()
+		opts.Queries[item.Name] = generateDocuments(ctx, templates, item.Name, file, readiness)
+		opts.Sampled[item.Name] = strings.Contains(templates.Lookup(file).Tree.Root.String(), mongo.SamplePrefix)
+	}
+	return &opts
+}
+
+// fail when a query template has $sample placeholders but nothing inserted
+// during the run could fill them in
+func requireSamples(opts *mongo.WorkerOptions, seed *mongo.SeedOptions) {
+	operations := opts.Mix
+	if opts.Scheduler != nil {
+		operations = opts.Scheduler.Rates
+	}
+	if seed != nil || operations.Weight(mongo.OperationInsert) > 0 ||
+		(opts.Bulk != nil && opts.Bulk.Mix.Weight(mongo.ModelInsert) > 0) {
+		return
+	}
+	for operation, sampled := range opts.Sampled {
+		if sampled {
+			log.WithField("operation", operation).Fatal("the query template samples inserted documents but the workload neither inserts nor seeds any")
+		}
+	}
+}
+
+// read the configured bulk write options
+func bulkOptions() *mongo.BulkOptions {
+	l := log.WithField("mix", viper.GetString("workload.bulk.mix"))
+	mix, err := workload.ParseMix(viper.GetString("workload.bulk.mix"))
+	if err == nil {
+		err = mongo.ValidateBulkMix(mix)
+	}
+	if err != nil {
+		l.WithField("error", err).Fatal("invalid bulk mix")
+	}
+	opts := mongo.BulkOptions{
+		Size:    viper.GetInt("workload.bulk.size"),
+		Ordered: viper.GetBool("workload.bulk.ordered"),
+		Mix:     mix,
+	}
+	if opts.Size < 1 {
+		l.WithField("size", opts.Size).Fatal("the bulk size must be at least 1")
+	}
+	return &opts
+}
+
+// read the configured transaction options
+func transactionOptions() *mongo.TransactionOptions {
+	opts := mongo.TransactionOptions{
+		Steps:        mongo.ParseTransactionSteps(viper.GetString("workload.transaction.steps")),
+		ReadConcern:  viper.GetString("workload.transaction.readConcern"),
+		WriteConcern: viper.GetString("workload.transaction.writeConcern"),
+		Contention:   viper.GetInt("workload.transaction.contention"),
+	}
+	if err := opts.Init(); err != nil {
+		log.WithFields(log.Fields{
+			"steps": viper.GetString("workload.transaction.steps"),
+			"error": err,
+		}).Fatal("invalid transaction")
+	}
+	return &opts
+}
+
+// operationTemplate returns the template of a named query or pipeline: the
+// template set in <section>.<name>, or <name>.template
+func operationTemplate(section string, name string) string {
+	if file := viper.GetString(section + "." + name); file != "" {
+		return file
+	}
+	return name + ".template"
+}
+
+// documentSource returns the documents to insert: the configured corpus file
+// if there is one, otherwise documents rendered from the templates
+func documentSource(ctx context.Context, readiness *telemetry.Readiness) chan mongo.Document {
+	file := viper.GetString("generator.corpus")
+	if file == "" {
+		return generateDocuments(ctx, parseTemplates(), "document", viper.GetString("templates.name"), readiness)
+	}
+
+	l := log.WithField("corpus", file)
+	condition := "corpus " + file
+	readiness.Require(condition)
+	corpus := generator.Corpus{
+		File:   file,
+		Buffer: viper.GetInt("generator.buffer"),
+		Loaded: func() {
+			readiness.Met(condition)
+		},
+	}
+	documents, err := corpus.Start(ctx)
+	if err != nil {
+		l.WithField("error", err).Fatal("could not open the corpus")
+	}
+	l.Info("Streaming documents from the corpus")
+	return documents
+}
+
+// read the seed options; nil is returned if seeding is not configured
+func seedOptions() *mongo.SeedOptions {
+	opts := mongo.SeedOptions{
+		Documents: viper.GetInt64("seed.documents"),
+		BatchSize: viper.GetInt("seed.batchSize"),
+		Workers:   viper.GetInt("seed.workers"),
+	}
+	if size := viper.GetString("seed.size"); size != "" {
+		bytes, err := parseSize(size)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"size":  size,
+				"error": err,
+			}).Fatal("invalid seed size")
+		}
+		opts.Bytes = bytes
+	}
+	if opts.Documents <= 0 && opts.Bytes <= 0 {
+		return nil
+	}
+	if opts.BatchSize <= 0 || opts.Workers <= 0 {
+		log.WithFields(log.Fields{
+			"batch":   opts.BatchSize,
+			"workers": opts.Workers,
+		}).Fatal("seed batch size and workers must be positive")
+	}
+	return &opts
+}
+
+// parse a size such as 512MB or 20GB into bytes.  Units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	scale := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			scale = unit.scale
+			break
 		}
 	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return int64(n * scale), nil
+}
+
+// pre-populate the collection before the measured test.  The seed phase is
+// discarded from the report.  Documents are taken from the insert generator
+// when the workload has one, otherwise a generator is started for seeding.
+func seedCollection(ctx context.Context, mdb *mongo.MongoLoad, seed *mongo.SeedOptions, opts *mongo.WorkerOptions, recorder *report.Recorder, readiness *telemetry.Readiness) {
+	seed.Samples = opts.Samples
+	documents := opts.Documents
+	if documents == nil {
+		seedCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		documents = documentSource(seedCtx, readiness)
+	}
+	mdb.Seed(ctx, documents, seed)
+	recorder.Reset()
+	mongo.SetPhase(mongo.PhaseSteady)
 }
 
 // start a new load test; This function blocks
-func startLoadGeneration(documents chan interface{}, mdb *mongo.MongoLoad) {
-	// TODO: wire these up
+func startLoadGeneration(ctx context.Context, mdb *mongo.MongoLoad, opts *mongo.WorkerOptions, profile *workload.Profile, recorder *report.Recorder, duration time.Duration) {
 	wg := new(sync.WaitGroup)
-	writes := viper.GetInt("goroutines.writes")
-	reads := viper.GetInt("goroutines.reads")
+	workers := viper.GetInt("goroutines.workers")
+	if opts.Profile != nil {
+		workers = opts.Profile.MaxConcurrency()
+	}
 	l := log.WithFields(log.Fields{
-		"writes": writes,
-		"reads":  reads,
+		"workers": workers,
+		"mix":     opts.Mix.String(),
 	})
 
-	l.Info("Creating load generation goroutines")
-	for i := 0; i < writes; i++ {
-		wg.Add(1)
-		go mdb.InsertOneRoutine(documents, wg)
+	if profile != nil {
+		profile.Start()
+	}
+	if opts.Scheduler != nil {
+		opts.Scheduler.Start(ctx, duration)
 	}
-	for i := 0; i < reads; i++ {
+
+	warmedUp := warmUp(ctx, recorder)
+
+	l.Info("Creating load generation goroutines")
+	recorder.Start()
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go mdb.ReadOneRoutine(wg)
+		go mdb.WorkerRoutine(ctx, i, opts, wg)
 	}
 	wg.Wait()
+	<-warmedUp
+	recorder.Stop()
+}
+
+// start the warm-up phase, if one is configured.  When the warm-up ends, or
+// the test is stopped, everything recorded so far is discarded and the
+// measured window restarts.  The returned channel is closed once that has
+// happened.
+func warmUp(ctx context.Context, recorder *report.Recorder) chan struct{} {
+	done := make(chan struct{})
+	warmup := viper.GetDuration("warmup")
+	if warmup <= 0 {
+		close(done)
+		return done
+	}
+
+	mongo.SetPhase(mongo.PhaseWarmup)
+	go func() {
+		defer close(done)
+		select {
+		case <-time.After(warmup):
+		case <-ctx.Done():
+		}
+		recorder.Reset()
+		recorder.Start()
+		mongo.SetPhase(mongo.PhaseSteady)
+	}()
+	return done
+}
+
+// write the raw latency histograms of this instance to the configured file
+// so they can be merged with those of other instances
+func writeHistograms(recorder *report.Recorder) {
+	file := viper.GetString("report.histograms")
+	if file == "" {
+		return
+	}
+	l := log.WithField("file", file)
+	hostname, _ := os.Hostname()
+	f, err := os.Create(file)
+	if err != nil {
+		l.WithField("error", err).Error("could not create the histogram file")
+		return
+	}
+	defer f.Close()
+	if err := recorder.WriteHistograms(f, VERSION, hostname); err != nil {
+		l.WithField("error", err).Error("could not write the histograms")
+		return
+	}
+	l.Info("histograms written")
+}
+
+// write a report to stdout or a file
+func writeReport(r *report.Report, format string, file string) error {
+	out := os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return r.Write(out, format)
+}
+
+// parse the configured thresholds
+func loadThresholds() []*report.Threshold {
+	thresholds, err := report.ParseThresholds(viper.GetStringSlice("slo.thresholds"))
+	if err != nil {
+		log.WithField("error", err).Fatal("invalid threshold")
+	}
+	return thresholds
+}
+
+// watchThresholds evaluates the thresholds that can be judged early every
+// interval while the test runs.  Once a threshold has been violated for
+// sustain evaluations in a row, over at least minOperations operations, the
+// test is stopped and the violations are sent on the returned channel.  Final
+// violations stop the test straight away.  Nothing is evaluated before the
+// steady phase.
+//
+// The channel is closed once the watcher has exited, so receiving from it
+// after cancelling ctx waits for the last evaluation to finish.
+func watchThresholds(ctx context.Context, stop context.CancelFunc, thresholds []*report.Threshold, recorder *report.Recorder, interval time.Duration, minOperations int64, sustain int) chan []report.ThresholdResult {
+	violations := make(chan []report.ThresholdResult, 1)
+	continuous := []*report.Threshold{}
+	for _, t := range thresholds {
+		if t.Continuous() {
+			continuous = append(continuous, t)
+		}
+	}
+	if len(continuous) == 0 {
+		close(violations)
+		return violations
+	}
+
+	go func() {
+		defer close(violations)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		violated := make(map[*report.Threshold]int) // consecutive violations
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if mongo.CurrentPhase() != mongo.PhaseSteady {
+				continue
+			}
+			r := report.New(recorder, VERSION, "")
+			results, _ := report.EvaluateThresholds(r, continuous)
+			failed := []report.ThresholdResult{}
+			for _, result := range results {
+				t := result.Threshold
+				switch {
+				case result.Passed:
+					violated[t] = 0
+				case t.Final():
+					failed = append(failed, result)
+				case result.Count >= minOperations:
+					if violated[t]++; violated[t] >= sustain {
+						failed = append(failed, result)
+					}
+				}
+			}
+			if len(failed) == 0 || ctx.Err() != nil {
+				continue // the test is already over
+			}
+			for _, result := range failed {
+				log.WithField("threshold", result.Message).Error("threshold violated; aborting the test")
+			}
+			violations <- failed
+			stop()
+			return
+		}
+	}()
+	return violations
+}
+
+// evaluate the thresholds against the final report, print the violations and
+// write the JUnit results.  false is returned if any threshold was violated.
+func checkThresholds(r *report.Report, thresholds []*report.Threshold) bool {
+	if len(thresholds) == 0 {
+		return true
+	}
+	results, passed := report.EvaluateThresholds(r, thresholds)
+	for _, result := range results {
+		if !result.Passed {
+			fmt.Fprintln(os.Stderr, "threshold violated:", result.Message)
+		}
+	}
+
+	if file := viper.GetString("slo.junit"); file != "" {
+		l := log.WithField("file", file)
+		f, err := os.Create(file)
+		if err != nil {
+			l.WithField("error", err).Error("could not create the junit file")
+			return passed
+		}
+		defer f.Close()
+		if err := r.WriteJUnit(f, results); err != nil {
+			l.WithField("error", err).Error("could not write the junit results")
+		}
+	}
+	return passed
+}
+
+// exit codes of the start and compare commands
+const (
+	exitThresholds  = 2
+	exitRegression  = 2
+	exitInterrupted = 130
+)
+
+// trapSignals returns a context that is cancelled on SIGINT or SIGTERM so the
+// load test can stop gracefully.  A second signal exits immediately.  The
+// returned cancel function stops the test the same way.
+func trapSignals() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.WithField("signal", sig).Warn("shutting down; waiting for in flight operations")
+		cancel()
+		sig = <-signals
+		log.WithField("signal", sig).Error("forced shutdown")
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, cancel
 }
 
 // startCmd represents the start command
@@ -215,26 +794,102 @@ var startCmd = &cobra.Command{
 			"duration": viper.GetDuration("duration"),
 		}).Info("Starting a new instance")
 
+		// Validate the workload before anything is started
+		mix := workloadMix()
+		keys := keySelector()
+		thresholds := loadThresholds()
+		if format := viper.GetString("report.format"); format != "none" && !report.ValidFormat(format) {
+			l.WithField("format", format).Fatal("invalid report format")
+		}
+
+		// Stop gracefully when asked to
+		ctx, stop := trapSignals()
+
 		// configureTelemetry
 		telemetry, ok := configureTelemetry(wg)
 		if !ok {
-			l.Error("Telemetry failed")
+			l.Fatal("Telemetry failed")
 		}
 		defer close(telemetry.pushGatewayExitChannel)
 
+		// Load the load profile, if there is one
+		profile := loadProfile(telemetry.registry)
+		duration := testDuration(profile)
+		if warmup := viper.GetDuration("warmup"); warmup >= duration {
+			l.WithFields(log.Fields{
+				"warmup":   warmup,
+				"duration": duration,
+			}).Fatal("the warm-up must be shorter than the test")
+		}
+
+		// Seeding must complete before the instance is ready
+		seed := seedOptions()
+		if seed != nil {
+			telemetry.readiness.Require("seed")
+		}
+
 		// Create the queue
-		q := createQueue(telemetry.registry)
+		q := createQueue(telemetry.registry, telemetry.latencyBuckets, keys != nil)
 
-		// Create a new Mongo Load Tester
-		mdb, cancel := createLoadTester(telemetry.registry, q)
+		// Create the scheduler for rate limited tests
+		scheduler := createScheduler(telemetry.registry, mix, profile)
 
 		// Start Document Generation
-		documentChannel := generateDocuments()
+		opts := workerOptions(ctx, mix, scheduler, profile, telemetry.readiness)
+		opts.Keys = keys
+		requireSamples(opts, seed)
 
-		// Start Load Generation
-		startLoadGeneration(documentChannel, mdb)
+		// Create a new Mongo Load Tester
+		recorder := report.NewRecorder()
+		mdb, cancel := createLoadTester(telemetry.registry, telemetry.latencyBuckets, q, recorder, duration)
+		telemetry.readiness.Met("mongo")
+
+		// Pre-populate the collection
+		if seed != nil {
+			seedCollection(ctx, mdb, seed, opts, recorder, telemetry.readiness)
+			telemetry.readiness.Met("seed")
+		}
+
+		// Abort early on violated thresholds when asked to
+		violations := make(chan []report.ThresholdResult)
+		close(violations)
+		if viper.GetBool("slo.abort") {
+			violations = watchThresholds(ctx, stop, thresholds, recorder, viper.GetDuration("slo.interval"),
+				viper.GetInt64("slo.minOperations"), viper.GetInt("slo.sustain"))
+		}
 
-		l.Info("load test completed")
+		// Start Load Generation
+		startLoadGeneration(ctx, mdb, opts, profile, recorder, duration)
+
+		stopped := ctx.Err() != nil
+		stop() // the test is over; stop watching thresholds
+		_, aborted := <-violations
+		interrupted := stopped && !aborted
+		switch {
+		case aborted:
+			l.Warn("load test aborted")
+		case interrupted:
+			l.Warn("load test interrupted")
+		default:
+			l.Info("load test completed")
+		}
+		r := report.New(recorder, VERSION, hostname)
+		if scheduler != nil {
+			r.DroppedTickets = scheduler.Dropped()
+		}
+		switch {
+		case aborted:
+			r.Status = report.StatusAborted
+		case interrupted:
+			r.Status = report.StatusInterrupted
+		}
+		if format := viper.GetString("report.format"); format != "none" {
+			if err := writeReport(r, format, viper.GetString("report.file")); err != nil {
+				l.WithField("error", err).Error("could not write the report")
+			}
+		}
+		writeHistograms(recorder)
+		passed := checkThresholds(r, thresholds) && !aborted
 
 		// clean up utility routines
 		if viper.GetBool("telemetry.pushgateway.enable") {
@@ -242,28 +897,18 @@ var startCmd = &cobra.Command{
 		}
 
 		wg.Wait()
+		if telemetry.server != nil {
+			telemetry.server.Shutdown(context.Background())
+		}
 		cancel()
-	},
-}
 
-func renderDocument(templates *template.Template, name string) interface{} {
-	var template string
-	var err error
-	l := log.WithFields(log.Fields{
-		"template": name,
-		"rendered": template,
-	})
-	start := time.Now()
-	//TODO: update docgen to support all file extensions
-	template, err = docgen.RenderTemplate(name, templates)
-	if err != nil {
-		l.WithFields(log.Fields{
-			"error": err,
-		}).Fatal("could not render the template")
-	}
-	l.Debug("new template rendered")
-	templateDuration.Observe(time.Since(start).Seconds())
-	return mongo.ConvertJSONtoBSON(template)
+		if interrupted {
+			os.Exit(exitInterrupted)
+		}
+		if !passed {
+			os.Exit(exitThresholds)
+		}
+	},
 }
 
 func init() {
@@ -271,11 +916,47 @@ func init() {
 
 	// General flags
 	startCmd.Flags().Duration("duration", 30*time.Second, "Duration of the load test")
-	startCmd.Flags().Int("write-routines", 1, "number of writing goroutines")
-	startCmd.Flags().Int("read-routines", 1, "number of reading goroutines")
+	startCmd.Flags().Int("workers", 2, "number of load generating goroutines")
+	startCmd.Flags().String("workload-mix", "insert:50,read:50", "weighted mix of operations performed by the workers (name:weight,...)")
 	viper.BindPFlag("duration", startCmd.Flags().Lookup("duration"))
-	viper.BindPFlag("goroutines.writes", startCmd.Flags().Lookup("write-routines"))
-	viper.BindPFlag("goroutines.reads", startCmd.Flags().Lookup("read-routines"))
+	startCmd.Flags().Duration("warmup", 0, "exclude this first part of the test from the report (e.g. 30s)")
+	viper.BindPFlag("warmup", startCmd.Flags().Lookup("warmup"))
+	viper.BindPFlag("goroutines.workers", startCmd.Flags().Lookup("workers"))
+	viper.BindPFlag("workload.mix", startCmd.Flags().Lookup("workload-mix"))
+	startCmd.Flags().Int("bulk-size", 100, "write models per bulk write")
+	startCmd.Flags().Bool("bulk-ordered", false, "stop a bulk write at the first failed write")
+	startCmd.Flags().String("bulk-mix", "insert:1", "weighted mix of the write models in a bulk write (insert|update|delete:weight,...)")
+	viper.BindPFlag("workload.bulk.size", startCmd.Flags().Lookup("bulk-size"))
+	viper.BindPFlag("workload.bulk.ordered", startCmd.Flags().Lookup("bulk-ordered"))
+	viper.BindPFlag("workload.bulk.mix", startCmd.Flags().Lookup("bulk-mix"))
+	startCmd.Flags().String("transaction-steps", "read,update", "comma separated operations of a transaction (read|insert|update|replace)")
+	startCmd.Flags().String("transaction-read-concern", "snapshot", "read concern of transactions (local|majority|snapshot)")
+	startCmd.Flags().String("transaction-write-concern", "majority", "write concern of transactions (majority or a number of members)")
+	startCmd.Flags().Int("transaction-contention", 0, "number of workers whose transactions share the same documents (0 for no sharing)")
+	viper.BindPFlag("workload.transaction.steps", startCmd.Flags().Lookup("transaction-steps"))
+	viper.BindPFlag("workload.transaction.readConcern", startCmd.Flags().Lookup("transaction-read-concern"))
+	viper.BindPFlag("workload.transaction.writeConcern", startCmd.Flags().Lookup("transaction-write-concern"))
+	viper.BindPFlag("workload.transaction.contention", startCmd.Flags().Lookup("transaction-contention"))
+	startCmd.Flags().Int("query-samples", 1000, "number of inserted documents query templates draw $sample values from")
+	viper.BindPFlag("workload.querySamples", startCmd.Flags().Lookup("query-samples"))
+	startCmd.Flags().String("key-distribution", workload.KeysFIFO, "how reads, updates and replaces pick ids (fifo|uniform|zipfian[:theta]|latest:count|hotspot:fraction:share)")
+	viper.BindPFlag("workload.keys", startCmd.Flags().Lookup("key-distribution"))
+
+	// Rate limiting
+	startCmd.Flags().String("target-rate", "", "target ops/sec per operation (name:rate,...); replaces the workload mix with an open loop scheduler")
+	startCmd.Flags().String("arrival", workload.ArrivalConstant, "arrival distribution of rate limited operations (constant|poisson)")
+	viper.BindPFlag("workload.rate", startCmd.Flags().Lookup("target-rate"))
+	viper.BindPFlag("workload.arrival", startCmd.Flags().Lookup("arrival"))
+
+	// Seeding
+	startCmd.Flags().Int64("seed-documents", 0, "insert this many documents before the test starts")
+	startCmd.Flags().String("seed-size", "", "insert documents until this much BSON has been written before the test starts (e.g. 20GB)")
+	startCmd.Flags().Int("seed-batch-size", 1000, "documents per insert while seeding")
+	startCmd.Flags().Int("seed-workers", 8, "number of seeding goroutines")
+	viper.BindPFlag("seed.documents", startCmd.Flags().Lookup("seed-documents"))
+	viper.BindPFlag("seed.size", startCmd.Flags().Lookup("seed-size"))
+	viper.BindPFlag("seed.batchSize", startCmd.Flags().Lookup("seed-batch-size"))
+	viper.BindPFlag("seed.workers", startCmd.Flags().Lookup("seed-workers"))
 
 	// Telemetry
 	startCmd.Flags().Bool("enable-pushgateway", false, "Enable pushing metrics to a prometheus push gateway")
@@ -284,16 +965,44 @@ func init() {
 	viper.BindPFlag("telemetry.pushgateway.frequency", startCmd.Flags().Lookup("pushgateway-frequency"))
 	startCmd.Flags().String("pushgateway-server", "127.0.0.1:9091", "Server and port of the prometheus push gateway")
 	viper.BindPFlag("telemetry.pushgateway.server", startCmd.Flags().Lookup("pushgateway-server"))
+	startCmd.Flags().String("metrics-listen", "", "Serve /metrics, /healthz and /readyz on this address (e.g. :9100)")
+	viper.BindPFlag("telemetry.listen", startCmd.Flags().Lookup("metrics-listen"))
+	startCmd.Flags().String("latency-buckets", "", "comma separated latency histogram buckets in seconds (default 0.5ms doubling to ~16s)")
+	viper.BindPFlag("telemetry.latencyBuckets", startCmd.Flags().Lookup("latency-buckets"))
+
+	// Thresholds
+	startCmd.Flags().StringSlice("threshold", nil, "fail the test unless operation.metric <|<=|>|>= value holds (e.g. \"insert.p99 < 25ms\"); may be repeated")
+	startCmd.Flags().Bool("abort-on-threshold", false, "stop the test as soon as a latency or error threshold is violated")
+	startCmd.Flags().Duration("threshold-interval", 10*time.Second, "how often thresholds are evaluated with --abort-on-threshold")
+	startCmd.Flags().String("junit-file", "", "write the threshold results to a JUnit XML file")
+	viper.BindPFlag("slo.thresholds", startCmd.Flags().Lookup("threshold"))
+	viper.BindPFlag("slo.abort", startCmd.Flags().Lookup("abort-on-threshold"))
+	viper.BindPFlag("slo.interval", startCmd.Flags().Lookup("threshold-interval"))
+	viper.BindPFlag("slo.junit", startCmd.Flags().Lookup("junit-file"))
+	startCmd.Flags().Int64("threshold-min-operations", 100, "operations needed before a latency or error rate threshold can abort the test")
+	startCmd.Flags().Int("threshold-sustain", 3, "consecutive evaluations a threshold must be violated before the test is aborted")
+	viper.BindPFlag("slo.minOperations", startCmd.Flags().Lookup("threshold-min-operations"))
+	viper.BindPFlag("slo.sustain", startCmd.Flags().Lookup("threshold-sustain"))
 
 	// Templates
-	startCmd.Flags().String("template-dir", ".", "Directory where document templates are located")
-	startCmd.Flags().String("template-name", "example.template", "Name of the template to use for generation")
-	viper.BindPFlag("templates.directory", startCmd.Flags().Lookup("template-dir"))
-	viper.BindPFlag("templates.name", startCmd.Flags().Lookup("template-name"))
-
-	// Queue
-	startCmd.Flags().Bool("enable-redis", false, "Enable redis document queue")
-	startCmd.Flags().String("redis-server", "127.0.0.1:6379", "Redis server and port")
-	viper.BindPFlag("queue.redis.enable", startCmd.Flags().Lookup("enable-redis"))
-	viper.BindPFlag("queue.redis.server", startCmd.Flags().Lookup("redis-server"))
+	startCmd.Flags().String("update-template", "", "Name of the template of update operators ($set, $inc, $push) used for updates")
+	viper.BindPFlag("templates.update", startCmd.Flags().Lookup("update-template"))
+
+	// Generation
+	startCmd.Flags().Int("generators", runtime.NumCPU(), "number of goroutines rendering documents from templates")
+	startCmd.Flags().Int("generator-buffer", 1024, "number of rendered documents buffered ahead of the workers")
+	startCmd.Flags().Bool("wait-for-documents", false, "wait for a freshly rendered document instead of reusing the last one")
+	viper.BindPFlag("generator.renderers", startCmd.Flags().Lookup("generators"))
+	viper.BindPFlag("generator.buffer", startCmd.Flags().Lookup("generator-buffer"))
+	viper.BindPFlag("generator.wait", startCmd.Flags().Lookup("wait-for-documents"))
+	startCmd.Flags().String("corpus", "", "insert documents from a corpus file written by generate instead of rendering templates")
+	viper.BindPFlag("generator.corpus", startCmd.Flags().Lookup("corpus"))
+
+	// Report
+	startCmd.Flags().String("report-format", report.FormatText, "format of the end of run report (text|json|csv|none)")
+	startCmd.Flags().String("report-file", "", "write the end of run report to a file instead of stdout")
+	viper.BindPFlag("report.format", startCmd.Flags().Lookup("report-format"))
+	startCmd.Flags().String("histogram-file", "", "write the raw latency histograms of this instance to a file for merging (see merge)")
+	viper.BindPFlag("report.file", startCmd.Flags().Lookup("report-file"))
+	viper.BindPFlag("report.histograms", startCmd.Flags().Lookup("histogram-file"))
 }
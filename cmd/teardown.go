@@ -0,0 +1,126 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/scbunn/mdbload/pkg/mongo"
+	"github.com/scbunn/mdbload/pkg/queue"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// teardownCmd represents the teardown command
+var teardownCmd = &cobra.Command{
+	Use:   "teardown",
+	Short: "Remove the collections and queue left behind by a load test",
+	Long: `Drop (or with --truncate, empty) the configured collection and every collection of the setup spec, and delete the
+redis queue key when redis is enabled.
+
+Only databases matching the --allow-databases pattern are touched; if any collection is outside the allow list nothing
+is removed.  Use --dry-run to list what would be removed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("spec")
+		truncate, _ := cmd.Flags().GetBool("truncate")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		pattern := viper.GetString("teardown.allow")
+		allow, err := regexp.Compile(pattern)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"pattern": pattern,
+				"error":   err,
+			}).Fatal("invalid database allow list")
+		}
+
+		spec := teardownSpec(file)
+		opts := mongoOptions()
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ConnectionTimeout+opts.ServerConnectTimeout)
+		client, err := mongo.Connect(ctx, opts)
+		cancel()
+		if err != nil {
+			log.WithField("error", err).Fatal("could not connect to mongo")
+		}
+		defer client.Disconnect(context.Background())
+
+		changes, err := mongo.Teardown(context.Background(), client, spec, &mongo.TeardownOptions{
+			Allow:    allow,
+			Truncate: truncate,
+			DryRun:   dryRun,
+		})
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if !viper.GetBool("queue.redis.enable") {
+			return
+		}
+		rq := queue.RedisQueue{
+			Server: viper.GetString("queue.redis.server"),
+			Key:    viper.GetString("queue.redis.key"),
+		}
+		count, err := rq.Purge(dryRun)
+		if err != nil {
+			fmt.Printf("could not delete redis key %s: %v\n", rq.Key, err)
+			os.Exit(1)
+		}
+		detail := fmt.Sprintf("deleted %d items", count)
+		if dryRun {
+			detail = "would be " + detail
+		}
+		fmt.Println(mongo.Change{Action: mongo.ChangeRemoved, Object: "redis key " + rq.Key, Detail: detail})
+	},
+}
+
+// teardownSpec returns the collections of the setup spec together with the
+// configured collection, each listed once.
+func teardownSpec(file string) *mongo.SetupSpec {
+	spec := setupSpec(file)
+	configured := mongo.CollectionSpec{
+		Database: viper.GetString("mongodb.database"),
+		Name:     viper.GetString("mongodb.collection"),
+	}
+	seen := map[string]bool{}
+	collections := []mongo.CollectionSpec{}
+	for _, c := range append(spec.Collections, configured) {
+		ns := c.Database + "." + c.Name
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		collections = append(collections, c)
+	}
+	spec.Collections = collections
+	return spec
+}
+
+func init() {
+	rootCmd.AddCommand(teardownCmd)
+	teardownCmd.Flags().String("spec", "", "read the collections to remove from a setup spec file instead of the configuration")
+	teardownCmd.Flags().Bool("truncate", false, "delete every document but keep the collections and their indexes")
+	teardownCmd.Flags().Bool("dry-run", false, "list what would be removed without removing it")
+	teardownCmd.Flags().String("allow-databases", mongo.DefaultTeardownAllow, "regular expression of the databases teardown may touch")
+	viper.BindPFlag("teardown.allow", teardownCmd.Flags().Lookup("allow-databases"))
+}
@@ -0,0 +1,176 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/scbunn/mdbload/pkg/generator"
+	"github.com/scbunn/mdbload/pkg/report"
+	"github.com/scbunn/mdbload/pkg/workload"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// templateCmd represents the template command
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Work with document templates",
+}
+
+// templateRenderCmd represents the template render command
+var templateRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render a template and summarize the documents it produces",
+	Long: `Render documents from a template and print them as canonical extended JSON, followed by the distribution of
+their BSON size and the types seen at every field.
+
+Documents can be validated against a JSON schema with --schema.  The exit status is 1 if any document failed to
+render, convert to BSON or validate.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		names, _ := cmd.Flags().GetString("name")
+		count, _ := cmd.Flags().GetInt("count")
+		schemaFile, _ := cmd.Flags().GetString("schema")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		if names == "" {
+			names = viper.GetString("templates.name")
+		}
+		mix, err := workload.ParseMix(names)
+		if err != nil {
+			log.WithField("error", err).Fatal("invalid template list")
+		}
+
+		var schema *gojsonschema.Schema
+		if schemaFile != "" {
+			path, _ := filepath.Abs(schemaFile)
+			schema, err = gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + path))
+			if err != nil {
+				log.WithFields(log.Fields{
+					"schema": schemaFile,
+					"error":  err,
+				}).Fatal("could not load the schema")
+			}
+		}
+
+		templates := parseTemplates()
+		types := templateTypes()
+		rng := workload.NewRand(viper.GetInt64("random.seed"), 0)
+		if viper.GetInt64("random.seed") != 0 {
+			if templates, err = generator.Seeded(templates, rng); err != nil {
+				log.WithField("error", err).Fatal("could not seed the templates")
+			}
+		}
+		recorder := report.NewRecorder()
+		fields := generator.NewFieldSummary()
+		failed := 0
+		total := 0
+		for _, item := range mix.Items() {
+			for i := 0; i < count; i++ {
+				total++
+				document, err := renderPreview(templates, item.Name, types, rng, schema)
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "%s document %d: %v\n", item.Name, i+1, err)
+					continue
+				}
+				recorder.RecordDocumentSize(len(document))
+				if err := fields.Add(document); err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "%s document %d: %v\n", item.Name, i+1, err)
+					continue
+				}
+				if !quiet {
+					printDocument(document)
+				}
+			}
+		}
+
+		d := report.New(recorder, VERSION, "").DocumentSize
+		fmt.Printf("\ndocument size (bytes): count %d min %.0f mean %.0f p50 %.0f p90 %.0f p99 %.0f max %.0f\n\n",
+			d.Count, d.Min, d.Mean, d.P50, d.P90, d.P99, d.Max)
+		fields.Write(os.Stdout)
+
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "\n%d of %d documents failed\n", failed, total)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(templateCmd)
+	templateCmd.AddCommand(templateRenderCmd)
+	templateRenderCmd.Flags().String("name", "", "template, or weighted list of templates, to render (default --template-name)")
+	templateRenderCmd.Flags().Int("count", 5, "number of documents to render from each template")
+	templateRenderCmd.Flags().String("schema", "", "validate every rendered document against this JSON schema file")
+	templateRenderCmd.Flags().Bool("quiet", false, "only print the summary")
+}
+
+// renderPreview renders, validates and converts a single document
+func renderPreview(templates *template.Template, name string, types generator.TypeHints, rng *rand.Rand, schema *gojsonschema.Schema) (bson.Raw, error) {
+	rendered, err := generator.RenderJSON(templates, name)
+	if err != nil {
+		return nil, err
+	}
+	if schema != nil {
+		if !generator.IsJSON(rendered) {
+			return nil, fmt.Errorf("only JSON templates can be validated against a schema")
+		}
+		result, err := schema.Validate(gojsonschema.NewStringLoader(rendered))
+		if err != nil {
+			return nil, fmt.Errorf("could not validate: %v", err)
+		}
+		if !result.Valid() {
+			errors := ""
+			for _, e := range result.Errors() {
+				errors += "\n  " + e.String()
+			}
+			return nil, fmt.Errorf("does not match the schema:%s", errors)
+		}
+	}
+	body, err := generator.ConvertToBSON(rendered)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = generator.ConvertTypes(body, types, rng); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(body)
+}
+
+// print a document as indented canonical extended JSON
+func printDocument(document bson.Raw) {
+	b, err := bson.MarshalExtJSON(document, true, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	indented := bytes.Buffer{}
+	if err := json.Indent(&indented, b, "", "  "); err != nil {
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Println(indented.String())
+}
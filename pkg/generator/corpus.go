@@ -0,0 +1,220 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scbunn/mdbload/pkg/mongo"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Corpus file formats
+const (
+	FormatBSON  = "bson"  // concatenated BSON documents, the layout of mongodump
+	FormatJSONL = "jsonl" // one extended JSON document per line, written canonical
+)
+
+// maximum size of a BSON document accepted by mongo
+const maxDocumentSize = 16 * 1024 * 1024
+
+// CorpusFormat returns the format of a corpus file from its extension
+func CorpusFormat(file string) string {
+	if strings.ToLower(filepath.Ext(file)) == ".bson" {
+		return FormatBSON
+	}
+	return FormatJSONL
+}
+
+// CorpusWriter writes documents to a corpus file
+type CorpusWriter struct {
+	w      *bufio.Writer
+	format string
+}
+
+// NewCorpusWriter returns a writer of documents in the given format
+func NewCorpusWriter(w io.Writer, format string) (*CorpusWriter, error) {
+	switch format {
+	case FormatBSON, FormatJSONL:
+	default:
+		return nil, fmt.Errorf("unknown corpus format %q", format)
+	}
+	return &CorpusWriter{
+		w:      bufio.NewWriter(w),
+		format: format,
+	}, nil
+}
+
+// Write writes a single document
+func (c *CorpusWriter) Write(document mongo.Document) error {
+	var b []byte
+	var err error
+	if c.format == FormatBSON {
+		b, err = bson.Marshal(document.Body)
+	} else {
+		b, err = bson.MarshalExtJSON(document.Body, true, false)
+		b = append(b, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(b)
+	return err
+}
+
+// Flush writes any buffered documents
+func (c *CorpusWriter) Flush() error {
+	return c.w.Flush()
+}
+
+// Corpus streams the documents of a corpus file, starting over at the end of
+// the file, so documents are inserted without rendering templates.  _id is
+// removed from the documents so every pass inserts new documents.
+type Corpus struct {
+	File   string
+	Buffer int    // capacity of the document channel
+	Loaded func() // called once the first document has been read
+}
+
+// Start opens the corpus and returns the channel documents are delivered on.
+// The channel is closed once ctx is cancelled.
+func (c *Corpus) Start(ctx context.Context) (chan mongo.Document, error) {
+	f, err := os.Open(c.File)
+	if err != nil {
+		return nil, err
+	}
+	documents := make(chan mongo.Document, c.Buffer)
+	go c.stream(ctx, f, documents)
+	return documents, nil
+}
+
+func (c *Corpus) stream(ctx context.Context, f *os.File, documents chan mongo.Document) {
+	defer close(documents)
+	defer f.Close()
+	l := log.WithField("corpus", c.File)
+	name := filepath.Base(c.File)
+	format := CorpusFormat(c.File)
+	r := bufio.NewReaderSize(f, 1024*1024)
+	count := 0
+	for {
+		body, err := readDocument(r, format)
+		if err == io.EOF {
+			if count == 0 {
+				l.Fatal("the corpus is empty")
+			}
+			l.WithField("documents", count).Debug("restarting the corpus")
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				l.WithField("error", err).Fatal("could not restart the corpus")
+			}
+			r.Reset(f)
+			count = 0
+			continue
+		}
+		if err != nil {
+			l.WithFields(log.Fields{
+				"document": count,
+				"error":    err,
+			}).Fatal("could not read the corpus")
+		}
+		if count == 0 && c.Loaded != nil {
+			c.Loaded()
+			c.Loaded = nil
+		}
+		count++
+
+		select {
+		case documents <- mongo.Document{Template: name, Body: body}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readDocument reads the next document of a corpus.  io.EOF is returned at
+// the end of the file.
+func readDocument(r *bufio.Reader, format string) (interface{}, error) {
+	if format == FormatJSONL {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(strings.TrimSpace(string(line))) > 0 {
+			err = nil // last line without a newline
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			return readDocument(r, format)
+		}
+		document := bson.D{}
+		if err := bson.UnmarshalExtJSON(line, false, &document); err != nil {
+			return nil, err
+		}
+		for i, e := range document {
+			if e.Key == "_id" {
+				document = append(document[:i], document[i+1:]...)
+				break
+			}
+		}
+		return document, nil
+	}
+
+	header, err := r.Peek(4)
+	if err == io.EOF && len(header) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truncated document: %v", err)
+	}
+	size := int(binary.LittleEndian.Uint32(header))
+	if size < 5 || size > maxDocumentSize {
+		return nil, fmt.Errorf("invalid document size %d", size)
+	}
+	document := make([]byte, size)
+	if _, err := io.ReadFull(r, document); err != nil {
+		return nil, fmt.Errorf("truncated document: %v", err)
+	}
+	return withoutID(bson.Raw(document))
+}
+
+// withoutID returns document without its _id element
+func withoutID(document bson.Raw) (bson.Raw, error) {
+	elements, err := document.Elements()
+	if err != nil {
+		return nil, err
+	}
+	stripped := make([]byte, 4, len(document))
+	found := false
+	for _, e := range elements {
+		if e.Key() == "_id" {
+			found = true
+			continue
+		}
+		stripped = append(stripped, e...)
+	}
+	if !found {
+		return document, nil
+	}
+	stripped = append(stripped, 0)
+	binary.LittleEndian.PutUint32(stripped, uint32(len(stripped)))
+	return bson.Raw(stripped), nil
+}
@@ -0,0 +1,112 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/scbunn/mdbload/pkg/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCorpusRoundTrip(t *testing.T) {
+	documents := []bson.D{
+		{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "first"}},
+		{{Key: "name", Value: "second"}, {Key: "count", Value: int32(2)}},
+	}
+	names := []string{"first", "second"}
+	for _, format := range []string{FormatBSON, FormatJSONL} {
+		buf := new(bytes.Buffer)
+		w, err := NewCorpusWriter(buf, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		for _, document := range documents {
+			if err := w.Write(mongo.Document{Body: document}); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		r := bufio.NewReader(buf)
+		for i := range documents {
+			body, err := readDocument(r, format)
+			if err != nil {
+				t.Fatalf("%s document %d: %v", format, i, err)
+			}
+			b, err := bson.Marshal(body)
+			if err != nil {
+				t.Fatalf("%s document %d: %v", format, i, err)
+			}
+			if _, err := bson.Raw(b).LookupErr("_id"); err == nil {
+				t.Errorf("%s document %d: _id was not removed", format, i)
+			}
+			if name := bson.Raw(b).Lookup("name").StringValue(); name != names[i] {
+				t.Errorf("%s document %d: got name %q, want %q", format, i, name, names[i])
+			}
+		}
+		if _, err := readDocument(r, format); err != io.EOF {
+			t.Errorf("%s: got %v at the end of the corpus, want io.EOF", format, err)
+		}
+	}
+}
+
+func TestCorpusFormat(t *testing.T) {
+	tests := map[string]string{
+		"corpus.bson":  FormatBSON,
+		"CORPUS.BSON":  FormatBSON,
+		"corpus.json":  FormatJSONL,
+		"corpus.jsonl": FormatJSONL,
+		"corpus":       FormatJSONL,
+	}
+	for file, want := range tests {
+		if got := CorpusFormat(file); got != want {
+			t.Errorf("%s: got %s, want %s", file, got, want)
+		}
+	}
+}
+
+func TestCorpusRelaxedJSON(t *testing.T) {
+	// mongoexport writes relaxed extended JSON by default
+	line := `{"_id":{"$oid":"5d0a1b2c3d4e5f6a7b8c9d0e"},"name":"first","count":2,"price":19.5,"date":{"$date":"2019-06-01T12:00:00Z"}}`
+	body, err := readDocument(bufio.NewReader(bytes.NewBufferString(line)), FormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bson.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := bson.Raw(b)
+	if _, err := document.LookupErr("_id"); err == nil {
+		t.Error("_id was not removed")
+	}
+	if count, ok := document.Lookup("count").Int32OK(); !ok || count != 2 {
+		t.Errorf("got count %v, want the int32 2", document.Lookup("count"))
+	}
+	if price, ok := document.Lookup("price").DoubleOK(); !ok || price != 19.5 {
+		t.Errorf("got price %v, want the double 19.5", document.Lookup("price"))
+	}
+	if _, ok := document.Lookup("date").DateTimeOK(); !ok {
+		t.Errorf("got date %v, want a date", document.Lookup("date"))
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// FieldSummary counts the BSON types seen at every field path of a set of
+// documents.  Array elements are summarized under path[].
+type FieldSummary struct {
+	documents int
+	fields    map[string]map[string]int // path:type:count
+}
+
+// NewFieldSummary returns an empty summary
+func NewFieldSummary() *FieldSummary {
+	return &FieldSummary{
+		fields: make(map[string]map[string]int),
+	}
+}
+
+// Add adds the fields of a document to the summary
+func (f *FieldSummary) Add(document bson.Raw) error {
+	f.documents++
+	return f.add("", document)
+}
+
+func (f *FieldSummary) add(prefix string, document bson.Raw) error {
+	elements, err := document.Elements()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		path := element.Key()
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if err := f.addValue(path, element.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FieldSummary) addValue(path string, value bson.RawValue) error {
+	types, ok := f.fields[path]
+	if !ok {
+		types = make(map[string]int)
+		f.fields[path] = types
+	}
+	types[value.Type.String()]++
+
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		return f.add(path, value.Document())
+	case bsontype.Array:
+		// array elements share the path of the array
+		elements, err := value.Array().Elements()
+		if err != nil {
+			return err
+		}
+		for _, element := range elements {
+			if err := f.addValue(path+"[]", element.Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write writes one line per field path with the types seen and how often
+func (f *FieldSummary) Write(w io.Writer) error {
+	paths := make([]string, 0, len(f.fields))
+	for path := range f.fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(t, "field\ttypes (of %d documents)\n", f.documents)
+	for _, path := range paths {
+		types := make([]string, 0, len(f.fields[path]))
+		for name, count := range f.fields[path] {
+			types = append(types, fmt.Sprintf("%s x%d", name, count))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(t, "%s\t%s\n", path, strings.Join(types, ", "))
+	}
+	return t.Flush()
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"math/rand"
+	"text/template"
+	"time"
+)
+
+// seeded dates fall in the year before this instant so they do not depend on
+// when the run started
+var seededEpoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	companies = []string{"Acme", "Globex", "Initech", "Umbrella", "Hooli", "Stark Industries", "Wayne Enterprises", "Soylent"}
+	streets   = []string{"Main St", "Oak Ave", "Elm St", "Maple Dr", "Cedar Ln", "Pine St", "Lake Rd", "Hill St"}
+	products  = []string{"widget", "gadget", "sprocket", "gizmo", "doohickey", "thingamajig"}
+)
+
+// Seeded returns a copy of templates whose random docgen functions draw from
+// rng.  docgen's own functions use the global math/rand source, which cannot
+// be seeded from Go 1.24 on, so a seeded generator renders with these
+// instead: randomInt, date, company, street, product and weightedSequence.
+// Any other docgen function is left as it is.
+func Seeded(templates *template.Template, rng *rand.Rand) (*template.Template, error) {
+	seeded, err := templates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return seeded.Funcs(template.FuncMap{
+		"randomInt": func(n int) int {
+			if n <= 0 {
+				return 0
+			}
+			return rng.Intn(n)
+		},
+		"date": func(layout string) string {
+			offset := time.Duration(rng.Int63n(int64(365 * 24 * time.Hour)))
+			return seededEpoch.Add(-offset).Format(layout)
+		},
+		"company":          func() string { return companies[rng.Intn(len(companies))] },
+		"street":           func() string { return streets[rng.Intn(len(streets))] },
+		"product":          func() string { return products[rng.Intn(len(products))] },
+		"weightedSequence": func() []int { return make([]int, 1+rng.Intn(5)) },
+	}), nil
+}
@@ -0,0 +1,203 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scbunn/docgen"
+	"github.com/scbunn/mdbload/pkg/mongo"
+	"github.com/scbunn/mdbload/pkg/workload"
+	log "github.com/sirupsen/logrus"
+)
+
+// Prometheus metrics
+var (
+	templateDuration = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace: "mdbload",
+			Name:      "generate_template_duration_seconds",
+			Help:      "The duration to generate a template",
+		},
+		[]string{"template"},
+	)
+
+	// time renderers spend waiting for room in the channel; high values mean
+	// the consumers, and so the cluster, are the bottleneck
+	generatorBlocked = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "generator_blocked_seconds_total",
+			Help:      "Time document renderers spent waiting for a full channel to drain",
+		},
+		[]string{"generator"},
+	)
+
+	generatorBuffered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "mdbload",
+			Name:      "generator_buffered_documents",
+			Help:      "The number of rendered documents waiting to be used",
+		},
+		[]string{"generator"},
+	)
+)
+
+// RegisterMetrics registers the metrics shared by every generator
+func RegisterMetrics(registry *prometheus.Registry) {
+	registry.MustRegister(templateDuration)
+	registry.MustRegister(generatorBlocked)
+	registry.MustRegister(generatorBuffered)
+}
+
+// Generator renders documents from a weighted mix of templates with a pool of
+// renderer goroutines
+type Generator struct {
+	Name      string // metric label, e.g. document or update
+	Templates *template.Template
+	Mix       *workload.Mix // template name:weight
+	Types     TypeHints
+	Renderers int
+	Seed      int64  // seeds template picks and UUIDs; 0 for a random stream
+	Buffer    int    // capacity of the document channel
+	Rendered  func() // called once the first document has been rendered
+	once      sync.Once
+}
+
+// Start starts the renderers and returns the channel documents are delivered
+// on.  The channel is closed once ctx is cancelled and every renderer has
+// stopped.
+//
+// Template picks, template functions and UUIDs come from a source derived
+// from the seed and the generator name (see Seeded).  Renderers would
+// interleave their documents by timing, so a seeded generator renders with a
+// single goroutine.
+func (g *Generator) Start(ctx context.Context) chan mongo.Document {
+	c := make(chan mongo.Document, g.Buffer)
+	if g.Seed != 0 && g.Renderers > 1 {
+		log.WithFields(log.Fields{
+			"generator": g.Name,
+			"renderers": g.Renderers,
+		}).Warn("a seeded generator uses a single renderer")
+		g.Renderers = 1
+	}
+	wg := new(sync.WaitGroup)
+	for i := 0; i < g.Renderers; i++ {
+		wg.Add(1)
+		go g.render(ctx, c, workload.NewRand(g.Seed, nameStream(g.Name)+i), wg)
+	}
+	go func() {
+		wg.Wait()
+		close(c)
+		log.WithFields(log.Fields{
+			"generator": g.Name,
+			"templates": g.Mix.String(),
+		}).Debug("document generation stopped")
+	}()
+	return c
+}
+
+// nameStream derives a random stream from a generator name so generators seeded
+// alike do not pick the same templates
+func nameStream(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32())
+}
+
+// render documents into c until ctx is cancelled.  Time spent blocked on a
+// full channel is recorded so a slow cluster can be told apart from a slow
+// generator.
+func (g *Generator) render(ctx context.Context, c chan mongo.Document, rng *rand.Rand, wg *sync.WaitGroup) {
+	defer wg.Done()
+	templates := g.Templates
+	if g.Seed != 0 {
+		var err error
+		if templates, err = Seeded(g.Templates, rng); err != nil {
+			log.WithFields(log.Fields{
+				"generator": g.Name,
+				"error":     err,
+			}).Fatal("could not seed the templates")
+		}
+	}
+	for ctx.Err() == nil {
+		name := g.Mix.Pick(rng)
+		document, err := Render(templates, name, g.Types, rng)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"template": name,
+				"error":    err,
+			}).Fatal("could not render the template")
+		}
+		if g.Rendered != nil {
+			g.once.Do(g.Rendered)
+		}
+
+		select {
+		case c <- document:
+		default:
+			start := time.Now()
+			select {
+			case c <- document:
+				generatorBlocked.WithLabelValues(g.Name).Add(time.Since(start).Seconds())
+			case <-ctx.Done():
+				return
+			}
+		}
+		generatorBuffered.WithLabelValues(g.Name).Set(float64(len(c)))
+	}
+}
+
+// Render renders a single document from the named template and converts it
+// to BSON with native types (see ConvertTypes)
+func Render(templates *template.Template, name string, types TypeHints, rng *rand.Rand) (mongo.Document, error) {
+	start := time.Now()
+	rendered, err := RenderJSON(templates, name)
+	if err != nil {
+		return mongo.Document{}, err
+	}
+	body, err := ConvertToBSON(rendered)
+	if err != nil {
+		return mongo.Document{}, err
+	}
+	if body, err = ConvertTypes(body, types, rng); err != nil {
+		return mongo.Document{}, err
+	}
+	templateDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	return mongo.Document{
+		Template: name,
+		Body:     body,
+	}, nil
+}
+
+// RenderJSON renders the named template without converting it to BSON.
+// Despite the name the result is YAML for templates written in YAML.
+func RenderJSON(templates *template.Template, name string) (string, error) {
+	//TODO: update docgen to support all file extensions
+	rendered, err := docgen.RenderTemplate(name, templates)
+	if err != nil {
+		return "", fmt.Errorf("could not render the template: %v", err)
+	}
+	log.WithField("template", name).Debug("new template rendered")
+	return rendered, nil
+}
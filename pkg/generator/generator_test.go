@@ -0,0 +1,62 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scbunn/docgen"
+	"github.com/scbunn/mdbload/pkg/workload"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSeededRender(t *testing.T) {
+	templates, err := docgen.ParseTemplates("../../templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func(seed int64) []bson.Raw {
+		rng := workload.NewRand(seed, nameStream("document"))
+		seeded, err := Seeded(templates, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents := []bson.Raw{}
+		for i := 0; i < 20; i++ {
+			for _, name := range []string{"example.template", "update.template", "by_name.template"} {
+				document, err := Render(seeded, name, nil, rng)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				b, err := bson.Marshal(document.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				documents = append(documents, b)
+			}
+		}
+		return documents
+	}
+
+	first, second := render(42), render(42)
+	if !reflect.DeepEqual(first, second) {
+		t.Error("the same seed rendered different documents")
+	}
+	if reflect.DeepEqual(first, render(43)) {
+		t.Error("different seeds rendered the same documents")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/scbunn/mdbload/pkg/workload"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BSON types a rendered string can be converted to
+const (
+	TypeDate     = "date"
+	TypeObjectID = "oid"
+	TypeDecimal  = "decimal"
+	TypeUUID     = "uuid"
+	TypeBinary   = "binary"
+)
+
+// TypeHints maps dotted field paths to the BSON type their string values are
+// converted to.  Array elements share the path of the array.
+type TypeHints map[string]string
+
+// date layouts accepted for dates, tried in order
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ConvertTypes converts the string values of a rendered document to native
+// BSON types.  A value is converted if its field has a type hint or if it is
+// a typed string such as "$date:2019-06-01T12:00:00Z", "$oid:", "$decimal:19.99"
+// or "$uuid:".  An empty $oid or $uuid generates a new value.  New UUIDs are
+// drawn from rng when it is not nil.  New ObjectIds hold the current time and
+// are never drawn from rng, so they differ between seeded runs.
+//
+// Templates cannot register functions with docgen, so typed strings are how a
+// template asks for a native type.
+func ConvertTypes(document interface{}, hints TypeHints, rng *rand.Rand) (interface{}, error) {
+	c := converter{hints: hints, rng: rng}
+	return c.value("", document)
+}
+
+type converter struct {
+	hints TypeHints
+	rng   *rand.Rand
+}
+
+func (c *converter) value(path string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case primitive.D:
+		for i := range v {
+			converted, err := c.value(join(path, v[i].Key), v[i].Value)
+			if err != nil {
+				return nil, err
+			}
+			v[i].Value = converted
+		}
+		return v, nil
+	case primitive.A:
+		for i := range v {
+			converted, err := c.value(path, v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	case string:
+		if strings.HasPrefix(v, "$") {
+			if i := strings.Index(v, ":"); i > 0 {
+				if converted, ok, err := c.convert(v[1:i], v[i+1:]); ok {
+					return converted, wrap(path, err)
+				}
+			}
+		}
+		if t, ok := c.hints[path]; ok {
+			if converted, ok, err := c.convert(t, v); ok {
+				return converted, wrap(path, err)
+			}
+		}
+	case int32, int64, float64:
+		// numeric dates are unix milliseconds
+		if c.hints[path] == TypeDate {
+			return primitive.DateTime(toInt64(v)), nil
+		}
+	}
+	return value, nil
+}
+
+// convert converts s to the named type.  ok is false if the type is unknown.
+func (c *converter) convert(t string, s string) (value interface{}, ok bool, err error) {
+	switch t {
+	case TypeDate:
+		value, err = parseDate(s)
+	case TypeObjectID:
+		if s == "" {
+			return primitive.NewObjectID(), true, nil
+		}
+		value, err = primitive.ObjectIDFromHex(s)
+	case TypeDecimal:
+		value, err = primitive.ParseDecimal128(s)
+	case TypeUUID:
+		var id uuid.UUID
+		switch {
+		case s == "" && c.rng != nil:
+			id = workload.NewUUID(c.rng)
+		case s == "":
+			id, err = uuid.NewV4()
+		default:
+			id, err = uuid.FromString(s)
+		}
+		value = primitive.Binary{Subtype: bsontype.BinaryUUID, Data: id.Bytes()}
+	case TypeBinary:
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(s)
+		value = primitive.Binary{Subtype: bsontype.BinaryGeneric, Data: data}
+	default:
+		return nil, false, nil
+	}
+	return value, true, err
+}
+
+func parseDate(s string) (primitive.DateTime, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return primitive.DateTime(t.UnixNano() / int64(time.Millisecond)), nil
+		}
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return primitive.DateTime(ms), nil
+	}
+	return 0, fmt.Errorf("%q is not a date", s)
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// Validate returns an error if a hint names an unknown type
+func (h TypeHints) Validate() error {
+	for path, t := range h {
+		switch t {
+		case TypeDate, TypeObjectID, TypeDecimal, TypeUUID, TypeBinary:
+		default:
+			return fmt.Errorf("field %s: unknown type %q", path, t)
+		}
+	}
+	return nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func wrap(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("field %s: %v", path, err)
+}
@@ -0,0 +1,179 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"math/rand"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConvertTypes(t *testing.T) {
+	oid := "5d1a2b3c4d5e6f7081928374"
+	tests := []struct {
+		name   string
+		value  interface{}
+		hints  TypeHints
+		check  func(interface{}) bool
+		failed bool
+	}{
+		{
+			name:  "typed date",
+			value: "$date:2019-06-01T12:00:00Z",
+			check: func(v interface{}) bool { return v == primitive.DateTime(1559390400000) },
+		},
+		{
+			name:  "typed date only",
+			value: "$date:2019-06-01",
+			check: func(v interface{}) bool { return v == primitive.DateTime(1559347200000) },
+		},
+		{
+			name:  "hinted unix milliseconds",
+			value: int64(1559347200000),
+			hints: TypeHints{"field": TypeDate},
+			check: func(v interface{}) bool { return v == primitive.DateTime(1559347200000) },
+		},
+		{
+			name:  "typed oid",
+			value: "$oid:" + oid,
+			check: func(v interface{}) bool {
+				id, ok := v.(primitive.ObjectID)
+				return ok && id.Hex() == oid
+			},
+		},
+		{
+			name:  "new oid",
+			value: "$oid:",
+			check: func(v interface{}) bool {
+				id, ok := v.(primitive.ObjectID)
+				return ok && id != primitive.NilObjectID
+			},
+		},
+		{
+			name:  "hinted decimal",
+			value: "19.99",
+			hints: TypeHints{"field": TypeDecimal},
+			check: func(v interface{}) bool {
+				d, ok := v.(primitive.Decimal128)
+				return ok && d.String() == "19.99"
+			},
+		},
+		{
+			name:  "new uuid",
+			value: "$uuid:",
+			check: func(v interface{}) bool {
+				b, ok := v.(primitive.Binary)
+				return ok && b.Subtype == bsontype.BinaryUUID && len(b.Data) == 16
+			},
+		},
+		{
+			name:  "typed binary",
+			value: "$binary:aGVsbG8=",
+			check: func(v interface{}) bool {
+				b, ok := v.(primitive.Binary)
+				return ok && b.Subtype == bsontype.BinaryGeneric && string(b.Data) == "hello"
+			},
+		},
+		{
+			name:  "unknown type is left alone",
+			value: "$price:10",
+			check: func(v interface{}) bool { return v == "$price:10" },
+		},
+		{
+			name:  "plain string is left alone",
+			value: "2019-06-01",
+			check: func(v interface{}) bool { return v == "2019-06-01" },
+		},
+		{name: "invalid date", value: "$date:june", failed: true},
+		{name: "invalid oid", value: "$oid:123", failed: true},
+		{name: "invalid hinted decimal", value: "cheap", hints: TypeHints{"field": TypeDecimal}, failed: true},
+	}
+	for _, test := range tests {
+		document := primitive.D{{Key: "field", Value: test.value}}
+		converted, err := ConvertTypes(document, test.hints, rand.New(rand.NewSource(1)))
+		if test.failed {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if v := converted.(primitive.D)[0].Value; !test.check(v) {
+			t.Errorf("%s: unexpected value %#v", test.name, v)
+		}
+	}
+}
+
+func TestConvertTypesNested(t *testing.T) {
+	document := primitive.D{
+		{Key: "order", Value: primitive.D{
+			{Key: "placed", Value: "2019-06-01"},
+			{Key: "items", Value: primitive.A{"1.50", "2.25"}},
+		}},
+	}
+	hints := TypeHints{"order.placed": TypeDate, "order.items": TypeDecimal}
+	converted, err := ConvertTypes(document, hints, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := converted.(primitive.D)[0].Value.(primitive.D)
+	if _, ok := order[0].Value.(primitive.DateTime); !ok {
+		t.Errorf("order.placed is %T, want a date", order[0].Value)
+	}
+	for _, item := range order[1].Value.(primitive.A) {
+		if _, ok := item.(primitive.Decimal128); !ok {
+			t.Errorf("order.items element is %T, want a decimal", item)
+		}
+	}
+}
+
+func TestConvertTypesSeeded(t *testing.T) {
+	convert := func(seed int64) primitive.Binary {
+		document := primitive.D{{Key: "id", Value: "$uuid:"}}
+		converted, err := ConvertTypes(document, nil, rand.New(rand.NewSource(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return converted.(primitive.D)[0].Value.(primitive.Binary)
+	}
+	if a, b := convert(42), convert(42); string(a.Data) != string(b.Data) {
+		t.Errorf("the same seed generated different UUIDs: %x and %x", a.Data, b.Data)
+	}
+	if a, b := convert(42), convert(43); string(a.Data) == string(b.Data) {
+		t.Errorf("different seeds generated the same UUID %x", a.Data)
+	}
+}
+
+func TestTypeHintsValidate(t *testing.T) {
+	tests := []struct {
+		hints  TypeHints
+		failed bool
+	}{
+		{TypeHints{}, false},
+		{TypeHints{"a": TypeDate, "b.c": TypeObjectID, "d": TypeDecimal, "e": TypeUUID, "f": TypeBinary}, false},
+		{TypeHints{"a": "timestamp"}, true},
+	}
+	for _, test := range tests {
+		if err := test.hints.Validate(); (err != nil) != test.failed {
+			t.Errorf("%v: got error %v, want failure %v", test.hints, err, test.failed)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scbunn/mdbload/pkg/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// IsJSON returns true if a rendered template is extended JSON rather than
+// YAML
+func IsJSON(rendered string) bool {
+	s := strings.TrimSpace(rendered)
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
+
+// ConvertToBSON converts a rendered template to a BSON document.  Templates
+// rendering a JSON object are read as relaxed extended JSON; anything else is
+// read as YAML, keeping the order of the keys.
+func ConvertToBSON(rendered string) (interface{}, error) {
+	if IsJSON(rendered) {
+		return mongo.ConvertJSONtoBSON(rendered)
+	}
+	var document yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(rendered), &document); err != nil {
+		return nil, fmt.Errorf("could not convert yaml to bson: %v", err)
+	}
+	return fromYAML(document), nil
+}
+
+func fromYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		d := make(primitive.D, len(v))
+		for i, item := range v {
+			d[i] = primitive.E{Key: fmt.Sprint(item.Key), Value: fromYAML(item.Value)}
+		}
+		return d
+	case []interface{}:
+		a := make(primitive.A, len(v))
+		for i, item := range v {
+			a[i] = fromYAML(item)
+		}
+		return a
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OperationAggregate prefixes the operations that run a pipeline template,
+// e.g. aggregate.daily_totals runs the daily_totals pipeline
+const OperationAggregate = "aggregate"
+
+var (
+	aggregateDocuments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "aggregate_documents_total",
+			Help:      "The number of documents returned by aggregation pipelines",
+		},
+		[]string{"pipeline"},
+	)
+)
+
+// PipelineName returns the name of the pipeline an aggregate operation runs,
+// or an empty string if operation is not an aggregation
+func PipelineName(operation string) string {
+	if strings.HasPrefix(operation, OperationAggregate+".") {
+		return strings.TrimPrefix(operation, OperationAggregate+".")
+	}
+	return ""
+}
+
+// Aggregation is an aggregation rendered from a pipeline template
+type Aggregation struct {
+	Pipeline     primitive.A
+	AllowDiskUse bool
+	MaxTime      time.Duration
+}
+
+// ParseAggregation reads a rendered pipeline template.  The template is a
+// document with the pipeline and optionally allowDiskUse and maxTimeMS.
+func ParseAggregation(document interface{}) (*Aggregation, error) {
+	d, ok := document.(primitive.D)
+	if !ok {
+		return nil, fmt.Errorf("a pipeline template must be a document")
+	}
+	a := Aggregation{}
+	for _, e := range d {
+		switch e.Key {
+		case "pipeline":
+			pipeline, ok := e.Value.(primitive.A)
+			if !ok {
+				return nil, fmt.Errorf("pipeline must be an array of stages")
+			}
+			a.Pipeline = pipeline
+		case "allowDiskUse":
+			allow, ok := e.Value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("allowDiskUse must be a boolean")
+			}
+			a.AllowDiskUse = allow
+		case "maxTimeMS":
+			ms, ok := toInt64(e.Value)
+			if !ok {
+				return nil, fmt.Errorf("maxTimeMS must be a number")
+			}
+			a.MaxTime = time.Duration(ms) * time.Millisecond
+		default:
+			return nil, fmt.Errorf("unknown pipeline field %q", e.Key)
+		}
+	}
+	if a.Pipeline == nil {
+		return nil, fmt.Errorf("a pipeline template must have a pipeline")
+	}
+	return &a, nil
+}
+
+// RunAggregation runs a rendered pipeline, filling in sampled values first,
+// and drains the cursor.  The number of documents returned is counted against
+// the pipeline name.  The aggregation uses the read preference of the client.
+//
+// The method returns true if the aggregation succeeded.  Operation latency is
+// recorded by the caller.
+func (m *MongoLoad) RunAggregation(name string, rendered interface{}, sample bson.Raw) bool {
+	operation := OperationAggregate + "." + name
+	l := log.WithField("pipeline", name)
+
+	resolved, err := resolveSamples(rendered, sample)
+	if err != nil {
+		l.WithField("error", err).Error("Could not fill in the pipeline")
+		m.fail(operation, err)
+		return false
+	}
+	a, err := ParseAggregation(resolved)
+	if err != nil {
+		l.WithField("error", err).Error("Invalid pipeline")
+		m.fail(operation, err)
+		return false
+	}
+
+	opts := options.Aggregate().SetAllowDiskUse(a.AllowDiskUse)
+	if a.MaxTime > 0 {
+		opts.SetMaxTime(a.MaxTime)
+	}
+	collection := m.db.Collection(m.options.Collection)
+	cursor, err := collection.Aggregate(m.ctx, a.Pipeline, opts)
+	if err != nil {
+		l.WithField("error", err).Error("Could not run the pipeline")
+		m.fail(operation, err)
+		return false
+	}
+	defer cursor.Close(m.ctx)
+
+	documents := 0
+	for cursor.Next(m.ctx) {
+		documents++
+	}
+	aggregateDocuments.WithLabelValues(name).Add(float64(documents))
+	if err := cursor.Err(); err != nil {
+		l.WithField("error", err).Error("Could not read the pipeline results")
+		m.fail(operation, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPipelineName(t *testing.T) {
+	tests := map[string]string{
+		"aggregate.daily_totals": "daily_totals",
+		"aggregate.":             "",
+		"aggregate":              "",
+		"query.daily_totals":     "",
+	}
+	for operation, want := range tests {
+		if got := PipelineName(operation); got != want {
+			t.Errorf("%s: got %q, want %q", operation, got, want)
+		}
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	pipeline := primitive.A{
+		primitive.D{{Key: "$match", Value: primitive.D{{Key: "status", Value: "open"}}}},
+		primitive.D{{Key: "$limit", Value: int32(10)}},
+	}
+	tests := []struct {
+		name     string
+		document interface{}
+		want     Aggregation
+		failed   bool
+	}{
+		{
+			name:     "pipeline only",
+			document: primitive.D{{Key: "pipeline", Value: pipeline}},
+			want:     Aggregation{Pipeline: pipeline},
+		},
+		{
+			name: "options",
+			document: primitive.D{
+				{Key: "pipeline", Value: pipeline},
+				{Key: "allowDiskUse", Value: true},
+				{Key: "maxTimeMS", Value: int32(5000)},
+			},
+			want: Aggregation{Pipeline: pipeline, AllowDiskUse: true, MaxTime: 5 * time.Second},
+		},
+		{
+			name:     "empty pipeline",
+			document: primitive.D{{Key: "pipeline", Value: primitive.A{}}},
+			want:     Aggregation{Pipeline: primitive.A{}},
+		},
+		{name: "not a document", document: pipeline, failed: true},
+		{name: "no pipeline", document: primitive.D{{Key: "allowDiskUse", Value: true}}, failed: true},
+		{name: "pipeline is not an array", document: primitive.D{{Key: "pipeline", Value: "$match"}}, failed: true},
+		{name: "allowDiskUse is not a boolean", document: primitive.D{{Key: "pipeline", Value: pipeline}, {Key: "allowDiskUse", Value: "yes"}}, failed: true},
+		{name: "maxTimeMS is not a number", document: primitive.D{{Key: "pipeline", Value: pipeline}, {Key: "maxTimeMS", Value: "5s"}}, failed: true},
+		{name: "unknown field", document: primitive.D{{Key: "pipeline", Value: pipeline}, {Key: "hint", Value: "_id_"}}, failed: true},
+	}
+	for _, test := range tests {
+		a, err := ParseAggregation(test.document)
+		if test.failed {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*a, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *a, test.want)
+		}
+	}
+}
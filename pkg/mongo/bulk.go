@@ -0,0 +1,307 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scbunn/mdbload/pkg/workload"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OperationBulk writes a batch of insert, update and delete models with a
+// single BulkWrite
+const OperationBulk = "bulk"
+
+// Write models a bulk write is made of
+const (
+	ModelInsert = "insert"
+	ModelUpdate = "update"
+	ModelDelete = "delete"
+)
+
+var (
+	bulkDocuments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "bulk_documents_total",
+			Help:      "The number of documents written by bulk writes",
+		},
+		[]string{"model"},
+	)
+
+	bulkErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "bulk_write_errors_total",
+			Help:      "The number of failed writes within bulk writes by server error code",
+		},
+		[]string{"code"},
+	)
+)
+
+// BulkOptions controls the batches written by the bulk operation
+type BulkOptions struct {
+	Size    int           // write models per batch
+	Ordered bool          // stop at the first failed write
+	Mix     *workload.Mix // model:weight of the write models in a batch
+}
+
+// ValidateBulkMix returns an error if the mix contains an unknown write model
+func ValidateBulkMix(mix *workload.Mix) error {
+	for _, item := range mix.Items() {
+		switch item.Name {
+		case ModelInsert, ModelUpdate, ModelDelete:
+		default:
+			return fmt.Errorf("unknown write model %q in bulk mix", item.Name)
+		}
+	}
+	return nil
+}
+
+// BulkWrite writes a batch of models and returns which of them were
+// applied.  Writes that failed in a BulkWriteException are counted by error
+// code.
+//
+// The method returns true if every write succeeded.  Operation latency is
+// recorded by the caller.
+func (m *MongoLoad) BulkWrite(models []mongo.WriteModel, kinds []string, ordered bool) ([]bool, bool) {
+	collection := m.db.Collection(m.options.Collection)
+	_, err := collection.BulkWrite(m.ctx, models, options.BulkWrite().SetOrdered(ordered))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"models": len(models),
+			"error":  err,
+		}).Error("Bulk write failed")
+		m.fail(OperationBulk, err)
+		if e, ok := err.(mongo.BulkWriteException); ok {
+			for _, we := range e.WriteErrors {
+				bulkErrors.WithLabelValues(strconv.Itoa(we.Code)).Inc()
+			}
+			if e.WriteConcernError != nil {
+				bulkErrors.WithLabelValues(strconv.Itoa(e.WriteConcernError.Code)).Inc()
+			}
+		}
+	}
+
+	applied := appliedModels(len(models), err, ordered)
+	for i, kind := range kinds {
+		if !applied[i] {
+			continue
+		}
+		bulkDocuments.WithLabelValues(kind).Inc()
+		if kind == ModelInsert {
+			documentCounter.WithLabelValues(CurrentPhase()).Inc()
+		}
+	}
+	return applied, err == nil
+}
+
+// appliedModels returns which of n models a bulk write that returned err
+// applied.  Only the failed writes of a BulkWriteException are known not to
+// have been applied, and with ordered writes nothing after the first of
+// them; any other error means nothing is known to have been written.
+func appliedModels(n int, err error, ordered bool) []bool {
+	applied := make([]bool, n)
+	e, ok := err.(mongo.BulkWriteException)
+	if err != nil && !ok {
+		return applied
+	}
+	for i := range applied {
+		applied[i] = true
+	}
+	if !ok {
+		return applied
+	}
+	first := n
+	for _, we := range e.WriteErrors {
+		if we.Index < 0 || we.Index >= n {
+			continue
+		}
+		applied[we.Index] = false
+		if we.Index < first {
+			first = we.Index
+		}
+	}
+	if ordered {
+		for i := first; i < n; i++ {
+			applied[i] = false
+		}
+	}
+	return applied
+}
+
+// withID returns a document that is certain to have an _id, generating an
+// ObjectId if it has none, along with the hex _id to queue.  The hex _id is
+// empty if the document has an _id that is not an ObjectId.
+func withID(document interface{}) (interface{}, string, error) {
+	var d primitive.D
+	switch v := document.(type) {
+	case primitive.D:
+		d = v
+	case bson.Raw:
+		if id, err := v.LookupErr("_id"); err == nil {
+			oid, _ := id.ObjectIDOK()
+			return v, hexID(oid), nil
+		}
+		if err := bson.Unmarshal(v, &d); err != nil {
+			return nil, "", err
+		}
+	default:
+		b, err := bson.Marshal(v)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := bson.Unmarshal(b, &d); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, e := range d {
+		if e.Key == "_id" {
+			oid, _ := e.Value.(primitive.ObjectID)
+			return d, hexID(oid), nil
+		}
+	}
+	oid := primitive.NewObjectID()
+	return append(primitive.D{{Key: "_id", Value: oid}}, d...), oid.Hex(), nil
+}
+
+func hexID(oid primitive.ObjectID) string {
+	if oid == primitive.NilObjectID {
+		return ""
+	}
+	return oid.Hex()
+}
+
+// bulk writes a batch of models picked from the bulk mix.  Inserted ids are
+// queued, and updated documents taken fresh from the queue are put back,
+// once the write has been applied.  The batch may be smaller than the
+// configured size when there is nothing to update or delete.
+func (w *worker) bulk() bool {
+	opts := w.opts.Bulk
+	models := make([]mongo.WriteModel, 0, opts.Size)
+	kinds := make([]string, 0, opts.Size)
+	queued := make([]*MongoDocument, 0, opts.Size) // ids to queue again
+
+	for i := 0; i < opts.Size; i++ {
+		switch kind := opts.Mix.Pick(w.rng); kind {
+		case ModelInsert:
+			document := w.nextDocument()
+			if document.Body == nil {
+				continue
+			}
+			body, id, err := withID(document.Body)
+			if err != nil {
+				w.l.WithField("error", err).Error("could not add an _id to a document")
+				continue
+			}
+			w.recordDocumentSize(document.Template, body)
+			models = append(models, mongo.NewInsertOneModel().SetDocument(body))
+			kinds = append(kinds, kind)
+			if id == "" {
+				queued = append(queued, nil)
+			} else {
+				queued = append(queued, &MongoDocument{Id: id, Hostname: w.hostname})
+			}
+		case ModelUpdate:
+			// render the update first so a fresh id is not dropped
+			update := w.nextUpdate()
+			if update.Body == nil {
+				continue
+			}
+			document, fresh := w.nextRead()
+			if document == nil {
+				continue
+			}
+			filter, err := idFilter(document.Id)
+			if err != nil {
+				w.l.WithField("id", document.Id).Error("Could not convert id to ObjectID")
+				continue
+			}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update.Body))
+			kinds = append(kinds, kind)
+			if fresh {
+				queued = append(queued, document)
+			} else {
+				queued = append(queued, nil)
+			}
+		case ModelDelete:
+			item := w.q.Dequeue()
+			if item == nil {
+				continue
+			}
+			document, ok := w.m.stringToMongoDocument(item)
+			if !ok {
+				continue
+			}
+			if w.read != nil && w.read.Id == document.Id {
+				w.read = nil
+			}
+			filter, err := idFilter(document.Id)
+			if err != nil {
+				continue
+			}
+			models = append(models, mongo.NewDeleteOneModel().SetFilter(filter))
+			kinds = append(kinds, kind)
+			queued = append(queued, nil)
+		}
+	}
+	if len(models) == 0 {
+		return false
+	}
+
+	start := w.start()
+	applied, _ := w.m.BulkWrite(models, kinds, opts.Ordered)
+	w.observeLatency(OperationBulk, start)
+
+	written := 0
+	for _, ok := range applied {
+		if ok {
+			written++
+		}
+	}
+	w.recorder.RecordDocuments(OperationBulk, written)
+	w.requeue(models, kinds, queued, applied)
+	return true
+}
+
+// requeue puts the ids of a bulk write back on the queue.  Inserted ids are
+// only queued once applied, fresh update ids are put back whatever the
+// outcome and deleted ids are never queued again.
+func (w *worker) requeue(models []mongo.WriteModel, kinds []string, queued []*MongoDocument, applied []bool) {
+	for i, document := range queued {
+		if document == nil {
+			continue
+		}
+		if kinds[i] == ModelInsert {
+			if !applied[i] {
+				continue
+			}
+			document.Timestamp = time.Now().UnixNano()
+			if w.opts.Samples != nil {
+				w.opts.Samples.Add(models[i].(*mongo.InsertOneModel).Document)
+			}
+		}
+		w.q.Enqueue(*document)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAppliedModels(t *testing.T) {
+	failed := func(indexes ...int) error {
+		e := mongo.BulkWriteException{}
+		for _, i := range indexes {
+			e.WriteErrors = append(e.WriteErrors, mongo.BulkWriteError{WriteError: mongo.WriteError{Index: i, Code: 11000}})
+		}
+		return e
+	}
+	tests := []struct {
+		name    string
+		err     error
+		ordered bool
+		want    []bool
+	}{
+		{"success", nil, false, []bool{true, true, true, true}},
+		{"ordered success", nil, true, []bool{true, true, true, true}},
+		{"unordered failures", failed(1, 3), false, []bool{true, false, true, false}},
+		{"ordered failure", failed(1), true, []bool{true, false, false, false}},
+		{"write concern error", mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}, false, []bool{true, true, true, true}},
+		{"index out of range", failed(-1, 7), true, []bool{true, true, true, true}},
+		{"network error", errors.New("connection reset"), false, []bool{false, false, false, false}},
+	}
+	for _, test := range tests {
+		if got := appliedModels(4, test.err, test.ordered); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWithID(t *testing.T) {
+	oid := primitive.NewObjectID()
+	raw := func(d bson.D) bson.Raw {
+		b, err := bson.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name     string
+		document interface{}
+		hex      string // "" for no queued id, "new" for a generated one
+	}{
+		{"document with an ObjectId", primitive.D{{Key: "_id", Value: oid}, {Key: "a", Value: 1}}, oid.Hex()},
+		{"document without an _id", primitive.D{{Key: "a", Value: 1}}, "new"},
+		{"document with a string _id", primitive.D{{Key: "_id", Value: "order-1"}}, ""},
+		{"raw document with an ObjectId", raw(bson.D{{Key: "_id", Value: oid}}), oid.Hex()},
+		{"raw document without an _id", raw(bson.D{{Key: "a", Value: 1}}), "new"},
+		{"raw document with a string _id", raw(bson.D{{Key: "_id", Value: "order-1"}}), ""},
+		{"map without an _id", bson.M{"a": 1}, "new"},
+	}
+	for _, test := range tests {
+		body, hex, err := withID(test.document)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		b, err := bson.Marshal(body)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		id, err := bson.Raw(b).LookupErr("_id")
+		if err != nil {
+			t.Errorf("%s: the document has no _id", test.name)
+			continue
+		}
+		switch test.hex {
+		case "new":
+			if got, ok := id.ObjectIDOK(); !ok || got.Hex() != hex || got == oid {
+				t.Errorf("%s: got _id %v and queued id %q, want a new ObjectId", test.name, id, hex)
+			}
+		default:
+			if hex != test.hex {
+				t.Errorf("%s: got queued id %q, want %q", test.name, hex, test.hex)
+			}
+		}
+		if first := bson.Raw(b).Index(0).Key(); first != "_id" && test.hex == "new" {
+			t.Errorf("%s: a generated _id is the %q field, want the first", test.name, first)
+		}
+	}
+}
+
+func TestBulkRequeue(t *testing.T) {
+	models := []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.D{}),
+		mongo.NewInsertOneModel().SetDocument(bson.D{}),
+		mongo.NewUpdateOneModel(),
+		mongo.NewUpdateOneModel(),
+		mongo.NewUpdateOneModel(),
+		mongo.NewDeleteOneModel(),
+	}
+	kinds := []string{ModelInsert, ModelInsert, ModelUpdate, ModelUpdate, ModelUpdate, ModelDelete}
+	documents := []*MongoDocument{{Id: "inserted"}, {Id: "failed insert"}, {Id: "updated"}, {Id: "failed update"}, nil, nil}
+	applied := []bool{true, false, true, false, true, true}
+
+	q := newTestQueue()
+	newTestWorker(0, q, &WorkerOptions{}).requeue(models, kinds, documents, applied)
+	want := []string{"inserted", "updated", "failed update"}
+	if got := queued(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+}
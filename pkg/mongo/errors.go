@@ -0,0 +1,108 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errInvalidID  = errors.New("id is not a valid ObjectID")
+	errNotMatched = errors.New("no document matched the filter")
+)
+
+// sampleError is returned when a query asks for a field the sampled document
+// does not have
+type sampleError string
+
+func (e sampleError) Error() string {
+	return "the sampled document has no field " + string(e)
+}
+
+// mongo server error codes
+const (
+	codeMaxTimeMSExpired = 50
+	codeDuplicateKey     = 11000
+)
+
+// ErrorClass groups an operation error into a broad class for reporting
+func ErrorClass(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case mongo.WriteException:
+		if e.WriteConcernError != nil {
+			return "write_concern"
+		}
+		return writeErrorsClass(e.WriteErrors)
+	case mongo.BulkWriteException:
+		if e.WriteConcernError != nil {
+			return "write_concern"
+		}
+		for _, we := range e.WriteErrors {
+			if we.Code == codeDuplicateKey {
+				return "duplicate_key"
+			}
+		}
+		return "write"
+	case mongo.CommandError:
+		switch {
+		case e.Code == codeMaxTimeMSExpired:
+			return "timeout"
+		case e.HasErrorLabel("NetworkError"):
+			return "network"
+		case e.HasErrorLabel("TransientTransactionError"):
+			return "transient_transaction"
+		}
+		return "command"
+	case sampleError:
+		return "no_sample"
+	case net.Error:
+		if e.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	switch err {
+	case errInvalidID:
+		return "invalid_id"
+	case errNotMatched, mongo.ErrNoDocuments:
+		return "not_found"
+	case context.DeadlineExceeded:
+		return "timeout"
+	case context.Canceled:
+		return "canceled"
+	}
+	if strings.Contains(err.Error(), "timeout") {
+		return "timeout"
+	}
+	return "other"
+}
+
+func writeErrorsClass(writeErrors mongo.WriteErrors) string {
+	for _, we := range writeErrors {
+		if we.Code == codeDuplicateKey {
+			return "duplicate_key"
+		}
+	}
+	return "write"
+}
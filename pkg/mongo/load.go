@@ -18,13 +18,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
-	"sync"
 	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/scbunn/mdbload/pkg/queue"
+	"github.com/scbunn/mdbload/pkg/report"
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -36,14 +34,8 @@ import (
 
 // Prometheus metrics
 var (
-	operationLatency = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Namespace: "mdbload",
-			Name:      "operation_latency_seconds",
-			Help:      "operational latency of mdbload",
-		},
-		[]string{"operation"},
-	)
+	// created when metrics are registered so the buckets can be configured
+	operationLatency *prometheus.HistogramVec
 
 	operationFailure = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -51,27 +43,52 @@ var (
 			Name:      "operation_failure_total",
 			Help:      "the number of failed mdbload mongo operations",
 		},
-		[]string{"operation"},
+		[]string{"operation", "phase"},
 	)
 
 	// need a separate document counter because an insert operation could
 	// insert more than one document
-	documentCounter = prometheus.NewCounter(
+	documentCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "mdbload",
 			Name:      "documents_total",
 			Help:      "The number of documents inserted",
 		},
+		[]string{"phase"},
 	)
 
 	// track document size distribution
-	documentSize = prometheus.NewHistogram(
+	documentSize = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "mdbload",
 			Name:      "document_size_bytes",
 			Help:      "The size of a document (as reported by sizeof)",
 			Buckets:   prometheus.ExponentialBuckets(1024, 2, 10),
 		},
+		[]string{"template"},
+	)
+)
+
+// Generator metrics observed by the workers
+var (
+	// writers reuse their last document when the generator has not produced
+	// a new one in time
+	documentReuse = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "document_reuse_total",
+			Help:      "The number of operations that reused a stale generated document",
+		},
+		[]string{"generator"},
+	)
+
+	generatorWait = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Namespace: "mdbload",
+			Name:      "generator_wait_seconds",
+			Help:      "Time workers waited for a freshly generated document",
+		},
+		[]string{"generator"},
 	)
 )
 
@@ -91,6 +108,8 @@ type MongoLoadOptions struct {
 	WriteAcks            int
 	Queue                *queue.Queue
 	PrometheusRegistry   *prometheus.Registry
+	LatencyBuckets       []float64 // operation latency histogram buckets (seconds)
+	Recorder             *report.Recorder
 }
 
 // MongoLoad type for managing load tests to a mongo cluster
@@ -101,6 +120,13 @@ type MongoLoad struct {
 	queue   *queue.Queue
 }
 
+// Document is a rendered document along with the name of the template it was
+// rendered from
+type Document struct {
+	Template string
+	Body     interface{}
+}
+
 // MongoDocument is the structure we stuff in a queue to read it later
 type MongoDocument struct {
 	Id        string
@@ -116,6 +142,7 @@ func configureOptions(opts *MongoLoadOptions) *options.ClientOptions {
 	o.SetConnectTimeout(opts.ConnectionTimeout)
 	o.SetServerSelectionTimeout(opts.ServerConnectTimeout)
 	o.SetSocketTimeout(opts.SocketTimeout)
+	o.SetMonitor(commandMonitor())
 
 	// Configure Read Preference
 	mode, err := readpref.ModeFromString(opts.ReadPreference)
@@ -151,41 +178,87 @@ func configureOptions(opts *MongoLoadOptions) *options.ClientOptions {
 	return o
 }
 
-func (m *MongoLoad) registerPrometheusMetrics(registry *prometheus.Registry) {
+func (m *MongoLoad) registerPrometheusMetrics(registry *prometheus.Registry, buckets []float64) {
+	operationLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "mdbload",
+			Name:      "operation_latency_seconds",
+			Help:      "operational latency of mdbload",
+			Buckets:   buckets,
+		},
+		[]string{"operation", "phase"},
+	)
 	registry.MustRegister(operationLatency)
+	transactionCommit = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "mdbload",
+			Name:      "transaction_commit_latency_seconds",
+			Help:      "latency of transaction commits",
+			Buckets:   buckets,
+		},
+	)
+	registry.MustRegister(transactionCommit)
+	registry.MustRegister(transactionAborts)
+	registry.MustRegister(transactionRetries)
+	registry.MustRegister(transactionUnknownCommit)
 	registry.MustRegister(operationFailure)
 	registry.MustRegister(documentCounter)
 	registry.MustRegister(documentSize)
+	registry.MustRegister(documentReuse)
+	registry.MustRegister(generatorWait)
+	registry.MustRegister(queryDocuments)
+	registry.MustRegister(queryBytes)
+	registry.MustRegister(sampleMissing)
+	registry.MustRegister(aggregateDocuments)
+	registry.MustRegister(bulkDocuments)
+	registry.MustRegister(bulkErrors)
 
 	// Explicitly set failure counters to zero
-	operationFailure.WithLabelValues("insert").Add(0)
-	operationFailure.WithLabelValues("read").Add(0)
+	operationFailure.WithLabelValues("insert", PhaseSteady).Add(0)
+	operationFailure.WithLabelValues("read", PhaseSteady).Add(0)
+	operationFailure.WithLabelValues("update", PhaseSteady).Add(0)
+	operationFailure.WithLabelValues("replace", PhaseSteady).Add(0)
+	operationFailure.WithLabelValues("delete", PhaseSteady).Add(0)
+	operationFailure.WithLabelValues(OperationBulk, PhaseSteady).Add(0)
+	operationFailure.WithLabelValues(OperationTransaction, PhaseSteady).Add(0)
+}
+
+// Connect creates a new client configured from the load test options and
+// pings the cluster.  Only the connection related options are used.
+func Connect(ctx context.Context, opts *MongoLoadOptions) (*mongo.Client, error) {
+	o := configureOptions(opts)
+	client, err := mongo.NewClient(o)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to mongo: %v", err)
+	}
+	if err = client.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("mongo client could not connect with background context: %v", err)
+	}
+	if err = client.Ping(ctx, nil); err != nil {
+		return nil, err
+	}
+	log.Info("Connected to mongo cluster")
+	return client, nil
 }
 
 // Init Initialize a new connection to mongo and set the database
 // If Init fails to initialize a database then all other mongo operations will
 // fail.
 func (m *MongoLoad) Init(ctx context.Context, opts *MongoLoadOptions) error {
-	o := configureOptions(opts)
-	m.registerPrometheusMetrics(opts.PrometheusRegistry)
+	m.registerPrometheusMetrics(opts.PrometheusRegistry, opts.LatencyBuckets)
+	if opts.Recorder == nil {
+		opts.Recorder = report.NewRecorder()
+	}
 
-	client, err := mongo.NewClient(o)
+	client, err := Connect(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("Could not connect to mongo: %v", err)
-	}
-	if err = client.Connect(ctx); err != nil {
-		return fmt.Errorf("mongo client could not connect with background context: %v", err)
+		return err
 	}
 
 	m.queue = opts.Queue
 	m.ctx = ctx
-	db := client.Database(opts.Database)
-	m.db = db
+	m.db = client.Database(opts.Database)
 	m.options = opts
-	if err = client.Ping(m.ctx, nil); err != nil {
-		return err
-	}
-	log.Info("Connected to mongo cluster")
 	return nil
 }
 
@@ -199,78 +272,203 @@ func (m *MongoLoad) Init(ctx context.Context, opts *MongoLoadOptions) error {
 // ObjectIDs are converted to hex and represented as strings if the _id is an
 // ObjectID
 func (m *MongoLoad) InsertDocuments(documents []interface{}) ([]string, bool) {
-	documentCounter.Add(float64(len(documents)))
+	documentCounter.WithLabelValues(CurrentPhase()).Add(float64(len(documents)))
 	collection := m.db.Collection(m.options.Collection)
 
 	start := time.Now()
 	result, err := collection.InsertMany(m.ctx, documents)
-	operationLatency.WithLabelValues("insert").Observe(time.Since(start).Seconds())
+	m.observeLatency("insert", start)
 
 	if err != nil {
-		operationFailure.WithLabelValues("insert").Add(float64(len(documents)))
+		operationFailure.WithLabelValues("insert", CurrentPhase()).Add(float64(len(documents)))
+		m.options.Recorder.RecordError("insert", ErrorClass(err))
 		return nil, false
 	}
 	return ObjectIDsToString(result.InsertedIDs), true
 }
 
-//InsertDocument attempts to insert a single document into a mongo collection.
+// InsertDocument attempts to insert a single document into a mongo collection.
 //
-//The method returns an OperationResult and string with the object id of the
-//inserted document.  If the operation was unsuccessful the string will be an
-//empty string.
+// The method returns the hex object id of the inserted document and true if
+// the insert succeeded.  The id is empty if the insert failed or the document
+// has an _id that is not an ObjectID; such documents are inserted but cannot
+// be queued for later reads.
 //
-//document is expected to be a BSON object
-func (m *MongoLoad) InsertDocument(document interface{}) (string, bool) {
+// The body of document is expected to be a BSON object.  Operation latency and
+// document size are recorded by the caller.
+func (m *MongoLoad) InsertDocument(document Document) (string, bool) {
 	collection := m.db.Collection(m.options.Collection)
-	documentCounter.Inc()
-	start := time.Now()
-	result, err := collection.InsertOne(m.ctx, document)
-	operationLatency.WithLabelValues("insert").Observe(time.Since(start).Seconds())
-
-	// record the size of the document
-	// TODO: this feels heavy, find a better way
-	b, _ := bson.Marshal(document)
-	documentSize.Observe(float64(len(b)))
-
+	documentCounter.WithLabelValues(CurrentPhase()).Inc()
+	result, err := collection.InsertOne(m.ctx, document.Body)
 	if err != nil {
 		log.Error(err)
-		operationFailure.WithLabelValues("insert").Inc()
+		m.fail("insert", err)
 		return "", false
 	}
-	return ObjectIDToString(result.InsertedID.(primitive.ObjectID)), true
+	oid, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		// only ObjectIDs can be queued and read back
+		log.WithField("id", result.InsertedID).Debug("inserted document does not have an ObjectID _id")
+		return "", true
+	}
+	return ObjectIDToString(oid), true
 }
 
-// ReadDocument finds a document by _id and returns the result
+// ReadDocument finds a document by _id and returns the result.  Operation
+// latency is recorded by the caller.
 func (m *MongoLoad) ReadDocument(id string) bson.Raw {
 	collection := m.db.Collection(m.options.Collection)
-	start := time.Now()
 	l := log.WithFields(log.Fields{
-		"id":       id,
-		"duration": time.Since(start).Seconds(),
+		"id": id,
 	})
 
-	// convert ID to ObjectID
-	oid, err := primitive.ObjectIDFromHex(id)
+	filter, err := idFilter(id)
 	if err != nil {
 		l.Error("Could not convert id to ObjectID")
-		operationFailure.WithLabelValues("read").Inc()
+		m.fail("read", err)
 		return nil
 	}
 
-	// Build a search filter based on ObjectID
-	filter := bson.D{{"_id", oid}}
-
 	bytes, err := collection.FindOne(m.ctx, filter).DecodeBytes()
-	operationLatency.WithLabelValues("read").Observe(time.Since(start).Seconds())
 	if err != nil {
 		l.WithFields(log.Fields{
 			"error": err,
 		}).Error("Could not read a document")
-		operationFailure.WithLabelValues("read").Inc()
+		m.fail("read", err)
 	}
 	return bytes
 }
 
+// UpdateDocument applies an update document to the document with the given
+// _id.  The update is expected to be a BSON object made up of update operators
+// such as $set, $inc and $push.
+//
+// The method returns true if a document was matched and updated.  Operation
+// latency is recorded by the caller.
+func (m *MongoLoad) UpdateDocument(id string, update interface{}) bool {
+	collection := m.db.Collection(m.options.Collection)
+	l := log.WithFields(log.Fields{
+		"id": id,
+	})
+
+	filter, err := idFilter(id)
+	if err != nil {
+		l.Error("Could not convert id to ObjectID")
+		m.fail("update", err)
+		return false
+	}
+
+	result, err := collection.UpdateOne(m.ctx, filter, update)
+	if err != nil {
+		l.WithFields(log.Fields{
+			"error": err,
+		}).Error("Could not update a document")
+		m.fail("update", err)
+		return false
+	}
+	if result.MatchedCount == 0 {
+		l.Debug("no document matched the update")
+		m.fail("update", errNotMatched)
+		return false
+	}
+	return true
+}
+
+// ReplaceDocument replaces the document with the given _id with a new
+// document.  document is expected to be a BSON object.
+//
+// The method returns true if a document was matched and replaced.
+// Operation latency is recorded by the caller.
+func (m *MongoLoad) ReplaceDocument(id string, document interface{}) bool {
+	collection := m.db.Collection(m.options.Collection)
+	l := log.WithFields(log.Fields{
+		"id": id,
+	})
+
+	filter, err := idFilter(id)
+	if err != nil {
+		l.Error("Could not convert id to ObjectID")
+		m.fail("replace", err)
+		return false
+	}
+
+	result, err := collection.ReplaceOne(m.ctx, filter, document)
+	if err != nil {
+		l.WithFields(log.Fields{
+			"error": err,
+		}).Error("Could not replace a document")
+		m.fail("replace", err)
+		return false
+	}
+	if result.MatchedCount == 0 {
+		l.Debug("no document matched the replacement")
+		m.fail("replace", errNotMatched)
+		return false
+	}
+	return true
+}
+
+// DeleteDocument deletes the document with the given _id.
+//
+// The method returns true if a document was deleted.  Operation latency is
+// recorded by the caller.
+func (m *MongoLoad) DeleteDocument(id string) bool {
+	collection := m.db.Collection(m.options.Collection)
+	l := log.WithFields(log.Fields{
+		"id": id,
+	})
+
+	filter, err := idFilter(id)
+	if err != nil {
+		l.Error("Could not convert id to ObjectID")
+		m.fail("delete", err)
+		return false
+	}
+
+	result, err := collection.DeleteOne(m.ctx, filter)
+	if err != nil {
+		l.WithFields(log.Fields{
+			"error": err,
+		}).Error("Could not delete a document")
+		m.fail("delete", err)
+		return false
+	}
+	if result.DeletedCount == 0 {
+		l.Debug("no document matched the delete")
+		m.fail("delete", errNotMatched)
+		return false
+	}
+	return true
+}
+
+// idFilter builds a search filter matching a hex encoded ObjectID
+func idFilter(id string) (bson.D, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errInvalidID
+	}
+	return bson.D{{"_id", oid}}, nil
+}
+
+// observeLatency records the latency of an operation that started at start
+func (m *MongoLoad) observeLatency(operation string, start time.Time) {
+	observeLatency(m.options.Recorder, operation, start)
+}
+
+// observeLatency records the latency of an operation in prometheus and a
+// report recorder
+func observeLatency(recorder *report.Recorder, operation string, start time.Time) {
+	latency := time.Since(start)
+	operationLatency.WithLabelValues(operation, CurrentPhase()).Observe(latency.Seconds())
+	recorder.RecordLatency(operation, latency)
+}
+
+// fail records a failed operation
+func (m *MongoLoad) fail(operation string, err error) {
+	operationFailure.WithLabelValues(operation, CurrentPhase()).Inc()
+	m.options.Recorder.RecordError(operation, ErrorClass(err))
+}
+
 // ObjectIDToString converts a mongo ObjectID to a string representation of
 // the hex value.
 func ObjectIDToString(oid primitive.ObjectID) string {
@@ -278,26 +476,25 @@ func ObjectIDToString(oid primitive.ObjectID) string {
 }
 
 // ObjectIDsToString converts an array of ObjectID primitives to their string
-// representations.
+// representations.  Ids that are not ObjectIDs are skipped.
 func ObjectIDsToString(oids []interface{}) []string {
 	var results []string
-	for _, oid := range oids {
-		results = append(results, ObjectIDToString(oid.(primitive.ObjectID)))
+	for _, id := range oids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			results = append(results, ObjectIDToString(oid))
+		}
 	}
 	return results
 }
 
-// ConvertJSONtoBSON converts a JSON string to a BSON object
-func ConvertJSONtoBSON(document string) interface{} {
+// ConvertJSONtoBSON converts a (relaxed extended) JSON string to a BSON
+// object
+func ConvertJSONtoBSON(document string) (interface{}, error) {
 	var bsonDocument interface{}
-	err := bson.UnmarshalExtJSON([]byte(document), false, &bsonDocument)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"json":  document,
-		}).Fatal("could not convert json to bson")
+	if err := bson.UnmarshalExtJSON([]byte(document), false, &bsonDocument); err != nil {
+		return nil, fmt.Errorf("could not convert json to bson: %v", err)
 	}
-	return bsonDocument
+	return bsonDocument, nil
 }
 
 // convert an untyped interface to a MongoDocument if possible
@@ -322,106 +519,3 @@ func (m *MongoLoad) stringToMongoDocument(document interface{}) (*MongoDocument,
 	l.Errorf("could not convert unknown type (%T) to MongoDocument", document)
 	return nil, false
 }
-
-// ReadOneRoutine reads documents based on queue items until test duration has expired.
-func (m *MongoLoad) ReadOneRoutine(waitGroup *sync.WaitGroup) {
-	defer waitGroup.Done()
-	id, _ := uuid.NewV4()
-	q := *m.queue
-	l := log.WithFields(log.Fields{
-		"goroutineID": id,
-	})
-
-	// block until we get an initial item from the queue
-	var document *MongoDocument
-	var nextDocument *MongoDocument
-	var ok bool
-	for {
-		item := q.Dequeue()
-		if item != nil {
-			// make sure we have something from the queue we can work with
-			document, ok = m.stringToMongoDocument(item)
-			if ok {
-				break // document is a valid MongoDocument
-			}
-		}
-		time.Sleep(1 * time.Second)
-	}
-
-	// if we are here then document should be a valid MongoDocument
-	l.Info("Starting to read documents")
-	timeout := time.After(m.options.TestDuration)
-
-	for {
-		select {
-		case <-timeout: // duration has elapsed, exit
-			l.Debug("exiting due to timeout")
-			return
-		default: // do nothing
-		}
-
-		m.ReadDocument(document.Id) // try and read a document
-
-		// try and get another item from the queue
-		item := q.Dequeue()
-		if nextDocument != nil {
-			// got something, is it useful?
-			nextDocument, ok = m.stringToMongoDocument(item)
-			if ok {
-				document = nextDocument
-				continue
-			}
-		}
-
-		// either we got no document or not a MongoDocument
-		l.WithFields(log.Fields{
-			"id": document.Id,
-		}).Debug("no item in queue, using old document")
-	}
-}
-
-// InsertOneRoutine writes documents in a loop until the duration has expired
-// or a request to exit.
-//
-// InsertOne expects a document channel
-func (m *MongoLoad) InsertOneRoutine(docs chan interface{}, waitGroup *sync.WaitGroup) {
-	defer waitGroup.Done()
-	hostname, _ := os.Hostname()
-	timeout := time.After(m.options.TestDuration)
-	id, _ := uuid.NewV4()
-	l := log.WithFields(log.Fields{
-		"goroutineID": id,
-	})
-
-	// block until we get a document
-	// Document should be a BSON object
-	document := <-docs
-	q := *m.queue
-	l.Info("starting to write documents")
-	for {
-		select {
-		case <-timeout: // duration has elapsed so bail
-			l.Debug("exiting due to timeout")
-			return
-		case document = <-docs: // get a new document if there is one
-			l.Debug("got a new document")
-		default: // don't block until timeout
-		}
-
-		// write a document
-		id, ok := m.InsertDocument(document)
-		if !ok {
-			l.WithFields(log.Fields{
-				"ok":       ok,
-				"id":       id,
-				"instance": hostname,
-			}).Error("failed to insert document")
-			continue // don't enqueue a failed insert
-		}
-		q.Enqueue(MongoDocument{
-			Id:        id,
-			Hostname:  hostname,
-			Timestamp: time.Now().UnixNano(),
-		})
-	}
-}
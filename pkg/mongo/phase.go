@@ -0,0 +1,46 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Phases of a load test.  Operation metrics are labelled with the phase they
+// were recorded in so only the steady state is used to judge a test.
+const (
+	PhaseSeed   = "seed"
+	PhaseWarmup = "warmup"
+	PhaseSteady = "steady"
+)
+
+var phase atomic.Value
+
+// SetPhase sets the phase subsequent operations are recorded in
+func SetPhase(p string) {
+	phase.Store(p)
+	log.WithField("phase", p).Info("entering test phase")
+}
+
+// CurrentPhase returns the current phase; steady if none has been set
+func CurrentPhase() string {
+	if p, ok := phase.Load().(string); ok {
+		return p
+	}
+	return PhaseSteady
+}
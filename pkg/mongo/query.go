@@ -0,0 +1,275 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scbunn/mdbload/pkg/workload"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OperationQuery prefixes the operations that run a query template, e.g.
+// query.by_customer runs the by_customer query
+const OperationQuery = "query"
+
+// SamplePrefix marks a string in a rendered query that is replaced with the
+// value of a field of a previously inserted document, e.g. "$sample:customer.id"
+const SamplePrefix = "$sample:"
+
+// Query metrics
+var (
+	queryDocuments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "query_documents_total",
+			Help:      "The number of documents returned by queries",
+		},
+		[]string{"query"},
+	)
+
+	queryBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "query_bytes_total",
+			Help:      "The BSON bytes of the documents returned by queries",
+		},
+		[]string{"query"},
+	)
+
+	// queries and pipelines skipped because nothing has been inserted to
+	// sample their $sample placeholders from
+	sampleMissing = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "query_sample_missing_total",
+			Help:      "The number of queries skipped because no inserted document could be sampled",
+		},
+		[]string{"operation"},
+	)
+)
+
+// QueryName returns the name of the query a query operation runs, or an
+// empty string if operation is not a query
+func QueryName(operation string) string {
+	if strings.HasPrefix(operation, OperationQuery+".") {
+		return strings.TrimPrefix(operation, OperationQuery+".")
+	}
+	return ""
+}
+
+// Query is a find rendered from a query template
+type Query struct {
+	Filter     interface{}
+	Sort       interface{}
+	Projection interface{}
+	Limit      int64
+}
+
+// ParseQuery reads a rendered query template.  The template is a document
+// with a filter and optionally a sort, a projection and a limit.
+func ParseQuery(document interface{}) (*Query, error) {
+	d, ok := document.(primitive.D)
+	if !ok {
+		return nil, fmt.Errorf("a query must be a document")
+	}
+	q := Query{Filter: bson.D{}}
+	for _, e := range d {
+		switch e.Key {
+		case "filter":
+			q.Filter = e.Value
+		case "sort":
+			q.Sort = e.Value
+		case "projection":
+			q.Projection = e.Value
+		case "limit":
+			limit, ok := toInt64(e.Value)
+			if !ok {
+				return nil, fmt.Errorf("limit must be a number")
+			}
+			q.Limit = limit
+		default:
+			return nil, fmt.Errorf("unknown query field %q", e.Key)
+		}
+	}
+	return &q, nil
+}
+
+// toInt64 converts the numeric types of a rendered template to an int64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// Samples is a fixed size reservoir of inserted documents that query
+// templates draw their values from.  Every inserted document is equally
+// likely to be held.
+type Samples struct {
+	mu        sync.Mutex
+	rng       *rand.Rand
+	documents []bson.Raw
+	size      int
+	seen      int64
+}
+
+// NewSamples returns an empty reservoir of size documents
+func NewSamples(size int, seed int64) *Samples {
+	return &Samples{
+		rng:  workload.NewRand(seed, -1),
+		size: size,
+	}
+}
+
+// Add offers an inserted document to the reservoir
+func (s *Samples) Add(document interface{}) {
+	b, err := bson.Marshal(document)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	if len(s.documents) < s.size {
+		s.documents = append(s.documents, b)
+		return
+	}
+	if i := s.rng.Int63n(s.seen); i < int64(s.size) {
+		s.documents[i] = b
+	}
+}
+
+// Pick returns a random document from the reservoir or nil if nothing has
+// been inserted yet
+func (s *Samples) Pick(rng *rand.Rand) bson.Raw {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.documents) == 0 {
+		return nil
+	}
+	return s.documents[rng.Intn(len(s.documents))]
+}
+
+// resolveSamples returns a copy of value with every $sample:<path> string
+// replaced by the value at path in sample.  The rendered query is left as is
+// so it can be reused.
+func resolveSamples(value interface{}, sample bson.Raw) (interface{}, error) {
+	switch v := value.(type) {
+	case primitive.D:
+		d := make(primitive.D, len(v))
+		for i, e := range v {
+			resolved, err := resolveSamples(e.Value, sample)
+			if err != nil {
+				return nil, err
+			}
+			d[i] = primitive.E{Key: e.Key, Value: resolved}
+		}
+		return d, nil
+	case primitive.A:
+		a := make(primitive.A, len(v))
+		for i, e := range v {
+			resolved, err := resolveSamples(e, sample)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = resolved
+		}
+		return a, nil
+	case string:
+		if !strings.HasPrefix(v, SamplePrefix) {
+			return v, nil
+		}
+		path := strings.TrimPrefix(v, SamplePrefix)
+		field, err := sample.LookupErr(strings.Split(path, ".")...)
+		if err != nil {
+			return nil, sampleError(path)
+		}
+		return field, nil
+	}
+	return value, nil
+}
+
+// RunQuery runs a rendered query, filling in sampled values first, and
+// drains the cursor.  The number of documents and bytes returned are counted
+// against the query name.
+//
+// The method returns true if the query succeeded.  Operation latency is
+// recorded by the caller.
+func (m *MongoLoad) RunQuery(name string, rendered interface{}, sample bson.Raw) bool {
+	operation := OperationQuery + "." + name
+	l := log.WithField("query", name)
+
+	resolved, err := resolveSamples(rendered, sample)
+	if err != nil {
+		l.WithField("error", err).Error("Could not fill in the query")
+		m.fail(operation, err)
+		return false
+	}
+	q, err := ParseQuery(resolved)
+	if err != nil {
+		l.WithField("error", err).Error("Invalid query")
+		m.fail(operation, err)
+		return false
+	}
+
+	opts := options.Find()
+	if q.Sort != nil {
+		opts.SetSort(q.Sort)
+	}
+	if q.Projection != nil {
+		opts.SetProjection(q.Projection)
+	}
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	collection := m.db.Collection(m.options.Collection)
+	cursor, err := collection.Find(m.ctx, q.Filter, opts)
+	if err != nil {
+		l.WithField("error", err).Error("Could not run the query")
+		m.fail(operation, err)
+		return false
+	}
+	defer cursor.Close(m.ctx)
+
+	documents, bytes := 0, 0
+	for cursor.Next(m.ctx) {
+		documents++
+		bytes += len(cursor.Current)
+	}
+	queryDocuments.WithLabelValues(name).Add(float64(documents))
+	queryBytes.WithLabelValues(name).Add(float64(bytes))
+	if err := cursor.Err(); err != nil {
+		l.WithField("error", err).Error("Could not read the query results")
+		m.fail(operation, err)
+		return false
+	}
+	return true
+}
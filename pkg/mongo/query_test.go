@@ -0,0 +1,170 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"query.by_customer": "by_customer",
+		"query.":            "",
+		"query":             "",
+		"read":              "",
+		"aggregate.totals":  "",
+	}
+	for operation, want := range tests {
+		if got := QueryName(operation); got != want {
+			t.Errorf("%s: got %q, want %q", operation, got, want)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	filter := primitive.D{{Key: "name", Value: "acme"}}
+	tests := []struct {
+		name     string
+		document interface{}
+		want     Query
+		failed   bool
+	}{
+		{
+			name:     "filter only",
+			document: primitive.D{{Key: "filter", Value: filter}},
+			want:     Query{Filter: filter},
+		},
+		{
+			name:     "empty",
+			document: primitive.D{},
+			want:     Query{Filter: bson.D{}},
+		},
+		{
+			name: "everything",
+			document: primitive.D{
+				{Key: "filter", Value: filter},
+				{Key: "sort", Value: primitive.D{{Key: "date", Value: int32(-1)}}},
+				{Key: "projection", Value: primitive.D{{Key: "name", Value: int32(1)}}},
+				{Key: "limit", Value: int32(10)},
+			},
+			want: Query{
+				Filter:     filter,
+				Sort:       primitive.D{{Key: "date", Value: int32(-1)}},
+				Projection: primitive.D{{Key: "name", Value: int32(1)}},
+				Limit:      10,
+			},
+		},
+		{
+			name:     "float limit",
+			document: primitive.D{{Key: "limit", Value: float64(5)}},
+			want:     Query{Filter: bson.D{}, Limit: 5},
+		},
+		{name: "not a document", document: primitive.A{}, failed: true},
+		{name: "unknown field", document: primitive.D{{Key: "hint", Value: "name_1"}}, failed: true},
+		{name: "string limit", document: primitive.D{{Key: "limit", Value: "10"}}, failed: true},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.document)
+		if test.failed {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*q, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *q, test.want)
+		}
+	}
+}
+
+func TestResolveSamples(t *testing.T) {
+	sample, err := bson.Marshal(bson.D{
+		{Key: "name", Value: "acme"},
+		{Key: "customer", Value: bson.D{{Key: "id", Value: int32(42)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := primitive.D{
+		{Key: "filter", Value: primitive.D{
+			{Key: "name", Value: "$sample:name"},
+			{Key: "customer.id", Value: primitive.D{{Key: "$in", Value: primitive.A{"$sample:customer.id", int32(7)}}}},
+			{Key: "status", Value: "open"},
+		}},
+	}
+
+	resolved, err := resolveSamples(rendered, bson.Raw(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := resolved.(primitive.D)[0].Value.(primitive.D)
+	if name := filter[0].Value.(bson.RawValue).StringValue(); name != "acme" {
+		t.Errorf("name: got %q, want acme", name)
+	}
+	in := filter[1].Value.(primitive.D)[0].Value.(primitive.A)
+	if id := in[0].(bson.RawValue).Int32(); id != 42 || in[1] != int32(7) {
+		t.Errorf("customer.id: got %v, want [42 7]", in)
+	}
+	if filter[2].Value != "open" {
+		t.Errorf("status: got %v, want open", filter[2].Value)
+	}
+	// the rendered query is reused, so it must not be modified
+	if rendered[0].Value.(primitive.D)[0].Value != "$sample:name" {
+		t.Error("the rendered query was modified")
+	}
+
+	if _, err := resolveSamples(primitive.D{{Key: "x", Value: "$sample:missing"}}, bson.Raw(sample)); err == nil {
+		t.Error("expected an error for a missing sample field")
+	} else if _, ok := err.(sampleError); !ok {
+		t.Errorf("got a %T error, want a sampleError", err)
+	}
+}
+
+func TestSamples(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewSamples(10, 1)
+	if s.Pick(rng) != nil {
+		t.Fatal("an empty reservoir returned a sample")
+	}
+	for i := 0; i < 1000; i++ {
+		s.Add(bson.D{{Key: "i", Value: int32(i)}})
+	}
+	if len(s.documents) != 10 || s.seen != 1000 {
+		t.Fatalf("the reservoir holds %d of %d documents, want 10", len(s.documents), s.seen)
+	}
+	// a reservoir over 1000 documents should not only hold the first ten
+	late := false
+	for _, d := range s.documents {
+		if d.Lookup("i").Int32() >= 10 {
+			late = true
+		}
+	}
+	if !late {
+		t.Error("the reservoir only holds the first documents")
+	}
+	if s.Pick(rng) == nil {
+		t.Error("a full reservoir returned no sample")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SeedOptions controls how the collection is pre-populated before a test
+type SeedOptions struct {
+	Documents int64    // number of documents to insert
+	Bytes     int64    // BSON bytes to insert; only used when Documents is 0
+	BatchSize int      // documents per InsertMany
+	Workers   int      // concurrent inserting goroutines
+	Samples   *Samples // offered every inserted document when queries are run
+}
+
+// Seed bulk loads documents from the generator as fast as possible and
+// enqueues their ids so reads start against a realistic working set.  Seed
+// blocks until the target has been reached, the generator stops or ctx is
+// cancelled and returns the number of documents and bytes inserted.
+//
+// Operations performed while seeding are recorded in the seed phase.
+func (m *MongoLoad) Seed(ctx context.Context, documents chan Document, opts *SeedOptions) (int64, int64) {
+	var claimed, inserted, size int64
+	hostname, _ := os.Hostname()
+	l := log.WithFields(log.Fields{
+		"documents": opts.Documents,
+		"bytes":     opts.Bytes,
+		"batch":     opts.BatchSize,
+		"workers":   opts.Workers,
+	})
+
+	// reserve room for one more document; false once the target is reached
+	reserve := func() bool {
+		if opts.Documents > 0 {
+			return atomic.AddInt64(&claimed, 1) <= opts.Documents
+		}
+		return atomic.LoadInt64(&size) < opts.Bytes
+	}
+
+	l.Info("seeding collection")
+	SetPhase(PhaseSeed)
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				batch := make([]interface{}, 0, opts.BatchSize)
+			fill:
+				for len(batch) < opts.BatchSize && reserve() {
+					select {
+					case document, ok := <-documents:
+						if !ok {
+							break fill
+						}
+						b, _ := bson.Marshal(document.Body)
+						atomic.AddInt64(&size, int64(len(b)))
+						batch = append(batch, document.Body)
+					case <-ctx.Done():
+						return
+					}
+				}
+				if len(batch) == 0 {
+					return
+				}
+
+				ids, ok := m.InsertDocuments(batch)
+				if !ok {
+					l.Error("failed to insert a seed batch")
+					continue
+				}
+				for _, id := range ids {
+					(*m.queue).Enqueue(MongoDocument{
+						Id:        id,
+						Hostname:  hostname,
+						Timestamp: time.Now().UnixNano(),
+					})
+				}
+				if opts.Samples != nil {
+					for _, document := range batch {
+						opts.Samples.Add(document)
+					}
+				}
+				atomic.AddInt64(&inserted, int64(len(batch)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	l.WithFields(log.Fields{
+		"inserted": inserted,
+		"size":     size,
+		"elapsed":  time.Since(start),
+	}).Info("seeding complete")
+	return inserted, size
+}
@@ -0,0 +1,570 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mongo server error codes returned by idempotent setup commands
+const (
+	codeAlreadyInitialized = 23
+)
+
+// Change actions reported by Setup
+const (
+	ChangeCreated   = "+"
+	ChangeModified  = "~"
+	ChangeRemoved   = "-"
+	ChangeUnchanged = "="
+	ChangeConflict  = "!"
+)
+
+// SetupSpec declares the state of the collections a load test runs against
+type SetupSpec struct {
+	Collections []CollectionSpec `mapstructure:"collections"`
+}
+
+// CollectionSpec declares a collection along with its options, indexes and
+// sharding.  Database and Name default to the configured database and
+// collection.
+type CollectionSpec struct {
+	Database         string                 `mapstructure:"database"`
+	Name             string                 `mapstructure:"name"`
+	Capped           bool                   `mapstructure:"capped"`
+	Size             int64                  `mapstructure:"size"`
+	Max              int64                  `mapstructure:"max"`
+	Validator        map[string]interface{} `mapstructure:"validator"`
+	ValidationLevel  string                 `mapstructure:"validationLevel"`
+	ValidationAction string                 `mapstructure:"validationAction"`
+	Collation        map[string]interface{} `mapstructure:"collation"`
+	Indexes          []IndexSpec            `mapstructure:"indexes"`
+	Shard            *ShardSpec             `mapstructure:"shard"`
+}
+
+// IndexSpec declares a single index.  Keys is an ordered list of
+// field:direction pairs where direction is 1, -1 or an index type such as
+// hashed, text or 2dsphere.
+type IndexSpec struct {
+	Name          string                 `mapstructure:"name"`
+	Keys          string                 `mapstructure:"keys"`
+	Unique        bool                   `mapstructure:"unique"`
+	Sparse        bool                   `mapstructure:"sparse"`
+	ExpireAfter   time.Duration          `mapstructure:"expireAfter"`
+	PartialFilter map[string]interface{} `mapstructure:"partialFilter"`
+	Collation     map[string]interface{} `mapstructure:"collation"`
+}
+
+// ShardSpec declares the shard key of a collection and how its initial
+// chunks are split.  NumInitialChunks only applies to hashed shard keys;
+// SplitPoints are applied in order after the collection is sharded.
+type ShardSpec struct {
+	Key              string                   `mapstructure:"key"`
+	Unique           bool                     `mapstructure:"unique"`
+	NumInitialChunks int                      `mapstructure:"numInitialChunks"`
+	SplitPoints      []map[string]interface{} `mapstructure:"splitPoints"`
+}
+
+// Change is a single difference between the spec and the cluster
+type Change struct {
+	Action string
+	Object string
+	Detail string
+}
+
+func (c Change) String() string {
+	if c.Detail == "" {
+		return fmt.Sprintf("%s %s", c.Action, c.Object)
+	}
+	return fmt.Sprintf("%s %s: %s", c.Action, c.Object, c.Detail)
+}
+
+// collectionInfo is the part of listCollections output setup looks at
+type collectionInfo struct {
+	Name    string `bson:"name"`
+	Options bson.M `bson:"options"`
+}
+
+// indexInfo is the part of listIndexes output setup looks at
+type indexInfo struct {
+	Name                    string      `bson:"name"`
+	Key                     bson.D      `bson:"key"`
+	Unique                  bool        `bson:"unique"`
+	Sparse                  bool        `bson:"sparse"`
+	ExpireAfterSeconds      interface{} `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.M      `bson:"partialFilterExpression"`
+	Collation               bson.M      `bson:"collation"`
+}
+
+// Setup applies a spec to the cluster and returns every change it made.
+// Setup is idempotent; applying the same spec twice only reports unchanged
+// objects the second time.  When drop is true every collection of the spec is
+// dropped before it is created.
+//
+// Options that cannot be changed on an existing collection (capped, size,
+// collation, shard key) are reported as conflicts and left alone.
+func Setup(ctx context.Context, client *mongo.Client, spec *SetupSpec, drop bool) ([]Change, error) {
+	changes := []Change{}
+	for _, c := range spec.Collections {
+		l := log.WithFields(log.Fields{
+			"database":   c.Database,
+			"collection": c.Name,
+		})
+		l.Info("applying collection spec")
+		cc, err := setupCollection(ctx, client, &c, drop)
+		changes = append(changes, cc...)
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+func setupCollection(ctx context.Context, client *mongo.Client, c *CollectionSpec, drop bool) ([]Change, error) {
+	changes := []Change{}
+	db := client.Database(c.Database)
+	ns := c.Database + "." + c.Name
+	object := "collection " + ns
+
+	if drop {
+		existing, err := findCollection(ctx, db, c.Name)
+		if err != nil {
+			return changes, err
+		}
+		if existing != nil {
+			if err := db.Collection(c.Name).Drop(ctx); err != nil {
+				return changes, fmt.Errorf("could not drop %s: %v", ns, err)
+			}
+			changes = append(changes, Change{ChangeRemoved, object, "dropped"})
+		}
+	}
+
+	existing, err := findCollection(ctx, db, c.Name)
+	if err != nil {
+		return changes, err
+	}
+	if existing == nil {
+		if err := db.RunCommand(ctx, createCommand(c)).Err(); err != nil {
+			return changes, fmt.Errorf("could not create %s: %v", ns, err)
+		}
+		changes = append(changes, Change{ChangeCreated, object, ""})
+	} else {
+		cc, err := updateCollection(ctx, db, c, existing)
+		changes = append(changes, cc...)
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	for _, index := range c.Indexes {
+		change, err := setupIndex(ctx, db, c, &index)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+
+	if c.Shard != nil {
+		cc, err := setupSharding(ctx, client, c)
+		changes = append(changes, cc...)
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// findCollection returns the listCollections entry of a collection or nil if
+// it does not exist
+func findCollection(ctx context.Context, db *mongo.Database, name string) (*collectionInfo, error) {
+	cursor, err := db.ListCollections(ctx, bson.D{{"name", name}})
+	if err != nil {
+		return nil, fmt.Errorf("could not list collections of %s: %v", db.Name(), err)
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		return nil, cursor.Err()
+	}
+	info := collectionInfo{}
+	if err := cursor.Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func createCommand(c *CollectionSpec) bson.D {
+	cmd := bson.D{{"create", c.Name}}
+	if c.Capped {
+		cmd = append(cmd, bson.E{"capped", true}, bson.E{"size", c.Size})
+		if c.Max > 0 {
+			cmd = append(cmd, bson.E{"max", c.Max})
+		}
+	}
+	cmd = append(cmd, validationOptions(c)...)
+	if len(c.Collation) > 0 {
+		cmd = append(cmd, bson.E{"collation", normalize(c.Collation)})
+	}
+	return cmd
+}
+
+func validationOptions(c *CollectionSpec) bson.D {
+	options := bson.D{}
+	if len(c.Validator) > 0 {
+		options = append(options, bson.E{"validator", normalize(c.Validator)})
+	}
+	if c.ValidationLevel != "" {
+		options = append(options, bson.E{"validationLevel", c.ValidationLevel})
+	}
+	if c.ValidationAction != "" {
+		options = append(options, bson.E{"validationAction", c.ValidationAction})
+	}
+	return options
+}
+
+// updateCollection brings the options of an existing collection in line with
+// the spec where the server allows it
+func updateCollection(ctx context.Context, db *mongo.Database, c *CollectionSpec, existing *collectionInfo) ([]Change, error) {
+	changes := []Change{}
+	object := "collection " + c.Database + "." + c.Name
+	options := existing.Options
+
+	capped, _ := options["capped"].(bool)
+	if capped != c.Capped || (c.Capped && !equivalent(options["size"], c.Size)) || (c.Max > 0 && !equivalent(options["max"], c.Max)) {
+		changes = append(changes, Change{ChangeConflict, object, "capped options differ; drop the collection to change them"})
+	}
+	if len(c.Collation) > 0 && !subset(c.Collation, options["collation"]) {
+		changes = append(changes, Change{ChangeConflict, object, "collation differs; drop the collection to change it"})
+	}
+
+	modified := []string{}
+	if len(c.Validator) > 0 && !equivalent(options["validator"], c.Validator) {
+		modified = append(modified, "validator")
+	}
+	if c.ValidationLevel != "" && options["validationLevel"] != c.ValidationLevel {
+		modified = append(modified, "validationLevel")
+	}
+	if c.ValidationAction != "" && options["validationAction"] != c.ValidationAction {
+		modified = append(modified, "validationAction")
+	}
+	if len(modified) == 0 {
+		if len(changes) == 0 {
+			changes = append(changes, Change{ChangeUnchanged, object, ""})
+		}
+		return changes, nil
+	}
+
+	cmd := append(bson.D{{"collMod", c.Name}}, validationOptions(c)...)
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return changes, fmt.Errorf("could not modify %s.%s: %v", c.Database, c.Name, err)
+	}
+	changes = append(changes, Change{ChangeModified, object, strings.Join(modified, ", ")})
+	return changes, nil
+}
+
+// setupIndex creates a missing index or recreates an index whose definition
+// differs from the spec
+func setupIndex(ctx context.Context, db *mongo.Database, c *CollectionSpec, index *IndexSpec) (Change, error) {
+	keys, err := ParseKeys(index.Keys)
+	if err != nil {
+		return Change{}, fmt.Errorf("index %s: %v", index.Name, err)
+	}
+	if index.Name == "" {
+		index.Name = indexName(keys)
+	}
+	object := fmt.Sprintf("index %s.%s.%s", c.Database, c.Name, index.Name)
+	indexes := db.Collection(c.Name).Indexes()
+
+	existing, err := findIndex(ctx, indexes, index.Name)
+	if err != nil {
+		return Change{}, err
+	}
+	action := ChangeCreated
+	if existing != nil {
+		differences := indexDifferences(keys, index, existing)
+		if len(differences) == 0 {
+			return Change{ChangeUnchanged, object, ""}, nil
+		}
+		if _, err := indexes.DropOne(ctx, index.Name); err != nil {
+			return Change{}, fmt.Errorf("could not drop %s: %v", object, err)
+		}
+		action = ChangeModified
+		defer log.WithFields(log.Fields{
+			"index":       index.Name,
+			"differences": differences,
+		}).Info("recreated index")
+	}
+
+	definition := bson.D{{"key", keys}, {"name", index.Name}}
+	if index.Unique {
+		definition = append(definition, bson.E{"unique", true})
+	}
+	if index.Sparse {
+		definition = append(definition, bson.E{"sparse", true})
+	}
+	if index.ExpireAfter > 0 {
+		definition = append(definition, bson.E{"expireAfterSeconds", int32(index.ExpireAfter.Seconds())})
+	}
+	if len(index.PartialFilter) > 0 {
+		definition = append(definition, bson.E{"partialFilterExpression", normalize(index.PartialFilter)})
+	}
+	if len(index.Collation) > 0 {
+		definition = append(definition, bson.E{"collation", normalize(index.Collation)})
+	}
+	cmd := bson.D{{"createIndexes", c.Name}, {"indexes", bson.A{definition}}}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return Change{}, fmt.Errorf("could not create %s: %v", object, err)
+	}
+
+	detail := index.Keys
+	if action == ChangeModified {
+		detail = "recreated with " + index.Keys
+	}
+	return Change{action, object, detail}, nil
+}
+
+func findIndex(ctx context.Context, indexes mongo.IndexView, name string) (*indexInfo, error) {
+	cursor, err := indexes.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list indexes: %v", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		info := indexInfo{}
+		if err := cursor.Decode(&info); err != nil {
+			return nil, err
+		}
+		if info.Name == name {
+			return &info, nil
+		}
+	}
+	return nil, cursor.Err()
+}
+
+// indexDifferences returns the names of the index options that differ
+func indexDifferences(keys bson.D, index *IndexSpec, existing *indexInfo) []string {
+	differences := []string{}
+	if !sameKeys(keys, existing.Key) {
+		differences = append(differences, "keys")
+	}
+	if index.Unique != existing.Unique {
+		differences = append(differences, "unique")
+	}
+	if index.Sparse != existing.Sparse {
+		differences = append(differences, "sparse")
+	}
+	var expire interface{}
+	if index.ExpireAfter > 0 {
+		expire = int64(index.ExpireAfter.Seconds())
+	}
+	if !equivalent(expire, existing.ExpireAfterSeconds) {
+		differences = append(differences, "expireAfter")
+	}
+	if !equivalent(emptyAsNil(index.PartialFilter), emptyAsNil(existing.PartialFilterExpression)) {
+		differences = append(differences, "partialFilter")
+	}
+	if len(index.Collation) > 0 && !subset(index.Collation, existing.Collation) {
+		differences = append(differences, "collation")
+	}
+	return differences
+}
+
+// setupSharding shards a collection and pre-splits its chunks.  An already
+// sharded collection is only compared against the spec.
+func setupSharding(ctx context.Context, client *mongo.Client, c *CollectionSpec) ([]Change, error) {
+	changes := []Change{}
+	ns := c.Database + "." + c.Name
+	object := "shard key " + ns
+	key, err := ParseKeys(c.Shard.Key)
+	if err != nil {
+		return changes, fmt.Errorf("shard key: %v", err)
+	}
+
+	existing := struct {
+		Key bson.D `bson:"key"`
+	}{}
+	filter := bson.D{{"_id", ns}, {"dropped", bson.D{{"$ne", true}}}}
+	err = client.Database("config").Collection("collections").FindOne(ctx, filter).Decode(&existing)
+	switch {
+	case err == nil:
+		if sameKeys(key, existing.Key) {
+			return append(changes, Change{ChangeUnchanged, object, ""}), nil
+		}
+		return append(changes, Change{ChangeConflict, object, "collection is sharded on a different key"}), nil
+	case err != mongo.ErrNoDocuments:
+		return changes, fmt.Errorf("could not read the sharding state of %s: %v", ns, err)
+	}
+
+	admin := client.Database("admin")
+	if err := admin.RunCommand(ctx, bson.D{{"enableSharding", c.Database}}).Err(); err != nil {
+		if e, ok := err.(mongo.CommandError); !ok || e.Code != codeAlreadyInitialized {
+			return changes, fmt.Errorf("could not enable sharding on %s: %v", c.Database, err)
+		}
+	}
+
+	cmd := bson.D{{"shardCollection", ns}, {"key", key}}
+	if c.Shard.Unique {
+		cmd = append(cmd, bson.E{"unique", true})
+	}
+	if c.Shard.NumInitialChunks > 0 {
+		cmd = append(cmd, bson.E{"numInitialChunks", c.Shard.NumInitialChunks})
+	}
+	if err := admin.RunCommand(ctx, cmd).Err(); err != nil {
+		return changes, fmt.Errorf("could not shard %s: %v", ns, err)
+	}
+	changes = append(changes, Change{ChangeCreated, object, c.Shard.Key})
+
+	for _, point := range c.Shard.SplitPoints {
+		middle := bson.D{}
+		for _, k := range key {
+			middle = append(middle, bson.E{k.Key, normalize(point[k.Key])})
+		}
+		if err := admin.RunCommand(ctx, bson.D{{"split", ns}, {"middle", middle}}).Err(); err != nil {
+			return changes, fmt.Errorf("could not split %s at %v: %v", ns, point, err)
+		}
+		changes = append(changes, Change{ChangeCreated, "chunk split " + ns, canonical(middle)})
+	}
+	return changes, nil
+}
+
+// ParseKeys parses an ordered, comma separated list of field:direction pairs
+// into an index or shard key document.  Numeric directions are stored as
+// numbers, anything else (hashed, text, 2dsphere) as a string.
+func ParseKeys(s string) (bson.D, error) {
+	keys := bson.D{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		i := strings.LastIndex(field, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("key %q is not in the form field:direction", field)
+		}
+		name := strings.TrimSpace(field[:i])
+		direction := strings.TrimSpace(field[i+1:])
+		if n, err := strconv.Atoi(direction); err == nil {
+			keys = append(keys, bson.E{name, int32(n)})
+		} else {
+			keys = append(keys, bson.E{name, direction})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %q", s)
+	}
+	return keys, nil
+}
+
+// indexName builds the default name mongo gives an index
+func indexName(keys bson.D) string {
+	parts := []string{}
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// sameKeys compares two key documents in order
+func sameKeys(a bson.D, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !equivalent(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// subset returns true if every field of want has an equivalent value in have
+func subset(want map[string]interface{}, have interface{}) bool {
+	m, ok := have.(bson.M)
+	if !ok {
+		return false
+	}
+	for k, v := range want {
+		if !equivalent(v, m[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equivalent compares two values after a round trip through BSON so that
+// numeric types and map ordering do not matter
+func equivalent(a, b interface{}) bool {
+	return canonical(a) == canonical(b)
+}
+
+func canonical(v interface{}) string {
+	b, err := bson.Marshal(bson.M{"v": normalize(v)})
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	m := bson.M{}
+	if err := bson.Unmarshal(b, &m); err != nil {
+		return fmt.Sprint(v)
+	}
+	j, err := json.Marshal(m["v"])
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(j)
+}
+
+func emptyAsNil(m map[string]interface{}) interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// normalize converts the map[interface{}]interface{} values produced by YAML
+// decoding into map[string]interface{} so they can be marshaled to BSON
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = normalize(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = normalize(v)
+		}
+		return m
+	case bson.M:
+		return normalize(map[string]interface{}(t))
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, v := range t {
+			a[i] = normalize(v)
+		}
+		return a
+	}
+	return v
+}
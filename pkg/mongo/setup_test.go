@@ -0,0 +1,199 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		keys   string
+		want   bson.D
+		name   string
+		failed bool
+	}{
+		{keys: "date:-1", want: bson.D{{Key: "date", Value: int32(-1)}}, name: "date_-1"},
+		{keys: "name:1, date:-1", want: bson.D{{Key: "name", Value: int32(1)}, {Key: "date", Value: int32(-1)}}, name: "name_1_date_-1"},
+		{keys: "customer.id:hashed", want: bson.D{{Key: "customer.id", Value: "hashed"}}, name: "customer.id_hashed"},
+		{keys: "", failed: true},
+		{keys: "name", failed: true},
+		{keys: ":1", failed: true},
+	}
+	for _, test := range tests {
+		keys, err := ParseKeys(test.keys)
+		if test.failed {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.keys)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.keys, err)
+			continue
+		}
+		if !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("%q: got %v, want %v", test.keys, keys, test.want)
+		}
+		if name := indexName(keys); name != test.name {
+			t.Errorf("%q: index name %q, want %q", test.keys, name, test.name)
+		}
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		want bool
+	}{
+		{"nil", nil, nil, true},
+		{"numeric types", int32(3600), int64(3600), true},
+		{"integer and float", int64(1), float64(1), true},
+		{"different numbers", int32(1), int32(-1), false},
+		{"nil and zero", nil, int32(0), false},
+		{
+			"yaml map and bson map",
+			map[interface{}]interface{}{"qty": map[interface{}]interface{}{"$gt": 5}},
+			bson.M{"qty": bson.M{"$gt": int32(5)}},
+			true,
+		},
+		{
+			"map order",
+			map[string]interface{}{"a": 1, "b": "x"},
+			bson.M{"b": "x", "a": int64(1)},
+			true,
+		},
+		{
+			"different maps",
+			map[string]interface{}{"a": 1},
+			bson.M{"a": int32(1), "b": int32(2)},
+			false,
+		},
+		{"arrays", []interface{}{1, "a"}, bson.A{int32(1), "a"}, true},
+	}
+	for _, test := range tests {
+		if got := equivalent(test.a, test.b); got != test.want {
+			t.Errorf("%s: equivalent(%v, %v) = %v, want %v", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestIndexDifferences(t *testing.T) {
+	existing := &indexInfo{
+		Name:                    "date_-1",
+		Key:                     bson.D{{Key: "date", Value: int32(-1)}},
+		ExpireAfterSeconds:      int32(3600),
+		PartialFilterExpression: bson.M{"qty": bson.M{"$gt": int32(5)}},
+		Collation:               bson.M{"locale": "en", "strength": int32(2)},
+	}
+	tests := []struct {
+		name  string
+		index IndexSpec
+		want  []string
+	}{
+		{
+			name: "same",
+			index: IndexSpec{
+				Keys:          "date:-1",
+				ExpireAfter:   time.Hour,
+				PartialFilter: map[string]interface{}{"qty": map[interface{}]interface{}{"$gt": 5}},
+				Collation:     map[string]interface{}{"locale": "en"},
+			},
+			want: []string{},
+		},
+		{
+			name: "everything",
+			index: IndexSpec{
+				Keys:      "date:1",
+				Unique:    true,
+				Sparse:    true,
+				Collation: map[string]interface{}{"locale": "fr"},
+			},
+			want: []string{"keys", "unique", "sparse", "expireAfter", "partialFilter", "collation"},
+		},
+	}
+	for _, test := range tests {
+		keys, err := ParseKeys(test.index.Keys)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := indexDifferences(keys, &test.index, existing); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUpdateCollection(t *testing.T) {
+	existing := &collectionInfo{
+		Name: "loadtest",
+		Options: bson.M{
+			"capped":          true,
+			"size":            int64(1 << 20),
+			"validator":       bson.M{"name": bson.M{"$exists": true}},
+			"validationLevel": "strict",
+		},
+	}
+	tests := []struct {
+		name string
+		spec CollectionSpec
+		want []string
+	}{
+		{
+			name: "unchanged",
+			spec: CollectionSpec{
+				Capped:          true,
+				Size:            1 << 20,
+				Validator:       map[string]interface{}{"name": map[interface{}]interface{}{"$exists": true}},
+				ValidationLevel: "strict",
+			},
+			want: []string{ChangeUnchanged + " collection test.loadtest"},
+		},
+		{
+			name: "capped size",
+			spec: CollectionSpec{Capped: true, Size: 1 << 30},
+			want: []string{ChangeConflict + " collection test.loadtest: capped options differ"},
+		},
+		{
+			name: "collation",
+			spec: CollectionSpec{Capped: true, Size: 1 << 20, Collation: map[string]interface{}{"locale": "en"}},
+			want: []string{ChangeConflict + " collection test.loadtest: collation differs"},
+		},
+	}
+	for _, test := range tests {
+		test.spec.Database = "test"
+		test.spec.Name = "loadtest"
+		// nothing is modified, so the database is never used
+		changes, err := updateCollection(context.Background(), nil, &test.spec, existing)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(changes) != len(test.want) {
+			t.Fatalf("%s: got %v, want %v", test.name, changes, test.want)
+		}
+		for i, change := range changes {
+			if !strings.HasPrefix(change.String(), test.want[i]) {
+				t.Errorf("%s: got %q, want %q", test.name, change.String(), test.want[i])
+			}
+		}
+	}
+}
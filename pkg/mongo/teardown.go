@@ -0,0 +1,93 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultTeardownAllow is the default pattern of databases teardown may touch
+const DefaultTeardownAllow = "^loadtest"
+
+// TeardownOptions controls how Teardown removes collections
+type TeardownOptions struct {
+	Allow    *regexp.Regexp // databases that may be touched
+	Truncate bool           // delete the documents but keep the collection and its indexes
+	DryRun   bool           // only report what would be removed
+}
+
+// Teardown drops or truncates the collections of a spec.  Every database is
+// checked against the allow list before anything is removed so a bad spec
+// leaves the cluster untouched.
+func Teardown(ctx context.Context, client *mongo.Client, spec *SetupSpec, opts *TeardownOptions) ([]Change, error) {
+	changes := []Change{}
+	for _, c := range spec.Collections {
+		if opts.Allow == nil || !opts.Allow.MatchString(c.Database) {
+			return changes, fmt.Errorf("database %s does not match the allow list %v", c.Database, opts.Allow)
+		}
+	}
+
+	for _, c := range spec.Collections {
+		db := client.Database(c.Database)
+		ns := c.Database + "." + c.Name
+		object := "collection " + ns
+		existing, err := findCollection(ctx, db, c.Name)
+		if err != nil {
+			return changes, err
+		}
+		if existing == nil {
+			changes = append(changes, Change{ChangeUnchanged, object, "does not exist"})
+			continue
+		}
+
+		collection := db.Collection(c.Name)
+		count, err := collection.EstimatedDocumentCount(ctx)
+		if err != nil {
+			return changes, fmt.Errorf("could not count the documents of %s: %v", ns, err)
+		}
+		detail := fmt.Sprintf("dropped %d documents", count)
+		if opts.Truncate {
+			detail = fmt.Sprintf("truncated %d documents", count)
+		}
+		if opts.DryRun {
+			changes = append(changes, Change{ChangeRemoved, object, "would be " + detail})
+			continue
+		}
+
+		if opts.Truncate {
+			_, err = collection.DeleteMany(ctx, bson.D{})
+		} else {
+			err = collection.Drop(ctx)
+		}
+		if err != nil {
+			return changes, fmt.Errorf("could not remove %s: %v", ns, err)
+		}
+		log.WithFields(log.Fields{
+			"database":   c.Database,
+			"collection": c.Name,
+			"documents":  count,
+			"truncate":   opts.Truncate,
+		}).Info("removed collection")
+		changes = append(changes, Change{ChangeRemoved, object, detail})
+	}
+	return changes, nil
+}
@@ -0,0 +1,404 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// OperationTransaction runs the configured transaction steps in a single
+// multi-document transaction
+const OperationTransaction = "transaction"
+
+// Steps a transaction can be made of
+const (
+	StepRead    = "read"
+	StepInsert  = "insert"
+	StepUpdate  = "update"
+	StepReplace = "replace"
+)
+
+// Transaction metrics
+var (
+	// created when metrics are registered so the buckets can be configured
+	transactionCommit prometheus.Histogram
+
+	transactionAborts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "transaction_aborts_total",
+			Help:      "The number of aborted transaction attempts",
+		},
+	)
+
+	transactionRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "transaction_transient_retries_total",
+			Help:      "The number of transactions retried after a TransientTransactionError",
+		},
+	)
+
+	transactionUnknownCommit = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "transaction_unknown_commit_total",
+			Help:      "The number of commits retried after an UnknownTransactionCommitResult",
+		},
+	)
+)
+
+// TransactionOptions controls the transaction operation
+type TransactionOptions struct {
+	Steps        []string // operations performed in order inside the transaction
+	ReadConcern  string   // local, majority or snapshot
+	WriteConcern string   // majority or a number of members
+
+	// Contention is the number of workers that share the documents of their
+	// transactions.  0 takes new documents from the queue for every
+	// transaction.
+	Contention int
+
+	transaction *options.TransactionOptions
+	mu          sync.Mutex
+	groups      map[int][]*MongoDocument
+}
+
+// Init validates the steps and concerns of the transaction
+func (t *TransactionOptions) Init() error {
+	if len(t.Steps) == 0 {
+		return fmt.Errorf("a transaction needs at least one step")
+	}
+	for _, step := range t.Steps {
+		switch step {
+		case StepRead, StepInsert, StepUpdate, StepReplace:
+		default:
+			return fmt.Errorf("unknown transaction step %q", step)
+		}
+	}
+	if t.Contention < 0 {
+		return fmt.Errorf("contention must not be negative")
+	}
+
+	t.transaction = options.Transaction()
+	switch t.ReadConcern {
+	case "local":
+		t.transaction.SetReadConcern(readconcern.Local())
+	case "majority":
+		t.transaction.SetReadConcern(readconcern.Majority())
+	case "snapshot":
+		t.transaction.SetReadConcern(readconcern.Snapshot())
+	default:
+		return fmt.Errorf("unknown read concern %q", t.ReadConcern)
+	}
+	if t.WriteConcern == "majority" {
+		t.transaction.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	} else {
+		w, err := strconv.Atoi(t.WriteConcern)
+		if err != nil || w < 0 {
+			return fmt.Errorf("invalid write concern %q", t.WriteConcern)
+		}
+		t.transaction.SetWriteConcern(writeconcern.New(writeconcern.W(w)))
+	}
+	t.groups = make(map[int][]*MongoDocument)
+	return nil
+}
+
+// ParseTransactionSteps parses a comma separated list of transaction steps
+func ParseTransactionSteps(s string) []string {
+	steps := []string{}
+	for _, step := range strings.Split(s, ",") {
+		if step = strings.TrimSpace(step); step != "" {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// Uses returns true if the transaction has a step of the given kind
+func (t *TransactionOptions) Uses(kind string) bool {
+	for _, step := range t.Steps {
+		if step == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionStep is a single operation of a transaction.  ID is the hex
+// _id of the document read, updated or replaced; Document is the document
+// inserted, the update or the replacement.
+type TransactionStep struct {
+	Kind     string
+	ID       string
+	Document interface{}
+}
+
+// transactionState follows a transaction through the command monitor
+type transactionState struct {
+	attempts  int
+	committed bool // a commit has been sent in the current attempt
+}
+
+type transactionKey struct{}
+
+// commandMonitor observes the commands the driver sends on behalf of
+// WithTransaction: commit latency, aborts and commit retries
+func commandMonitor() *event.CommandMonitor {
+	finished := func(e event.CommandFinishedEvent) {
+		if e.CommandName == "commitTransaction" && transactionCommit != nil {
+			transactionCommit.Observe(time.Duration(e.DurationNanos).Seconds())
+		}
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			switch e.CommandName {
+			case "commitTransaction":
+				// a second commit in one attempt is a retry of an unknown
+				// commit result
+				if t, ok := ctx.Value(transactionKey{}).(*transactionState); ok {
+					if t.committed {
+						transactionUnknownCommit.Inc()
+					}
+					t.committed = true
+				}
+			case "abortTransaction":
+				transactionAborts.Inc()
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent)
+		},
+	}
+}
+
+// RunTransaction runs the steps in a single transaction with
+// session.WithTransaction, which retries the whole transaction on a
+// TransientTransactionError and the commit on an
+// UnknownTransactionCommitResult.
+//
+// The method returns true if the transaction committed.  Operation latency
+// is recorded by the caller.
+func (m *MongoLoad) RunTransaction(steps []TransactionStep, opts *TransactionOptions) bool {
+	l := log.WithField("steps", len(steps))
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		l.WithField("error", err).Error("Could not start a session")
+		m.fail(OperationTransaction, err)
+		return false
+	}
+	defer session.EndSession(m.ctx)
+
+	collection := m.db.Collection(m.options.Collection)
+	state := &transactionState{}
+	ctx := context.WithValue(m.ctx, transactionKey{}, state)
+	_, err = session.WithTransaction(ctx, func(sctx mongo.SessionContext) (interface{}, error) {
+		state.attempts++
+		state.committed = false
+		if state.attempts > 1 {
+			transactionRetries.Inc()
+		}
+		for _, step := range steps {
+			if err := runStep(sctx, collection, step); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}, opts.transaction)
+	if err != nil {
+		l.WithFields(log.Fields{
+			"attempts": state.attempts,
+			"error":    err,
+		}).Error("Transaction failed")
+		m.fail(OperationTransaction, err)
+		return false
+	}
+	return true
+}
+
+// runStep performs a single step of a transaction
+func runStep(ctx mongo.SessionContext, collection *mongo.Collection, step TransactionStep) error {
+	if step.Kind == StepInsert {
+		_, err := collection.InsertOne(ctx, step.Document)
+		return err
+	}
+	filter, err := idFilter(step.ID)
+	if err != nil {
+		return err
+	}
+	switch step.Kind {
+	case StepRead:
+		err = collection.FindOne(ctx, filter).Err()
+	case StepUpdate:
+		_, err = collection.UpdateOne(ctx, filter, step.Document)
+	case StepReplace:
+		_, err = collection.ReplaceOne(ctx, filter, step.Document)
+	}
+	return err
+}
+
+// documents returns the documents a worker's transaction reads and writes,
+// one for every step that is not an insert.  Workers in the same contention
+// group share the same documents for the whole test.  fresh is true when
+// the documents were taken from the queue for this transaction alone.
+func (t *TransactionOptions) documents(w *worker) (documents []*MongoDocument, fresh []bool) {
+	take := func() ([]*MongoDocument, []bool) {
+		documents, fresh = []*MongoDocument{}, []bool{}
+		for _, step := range t.Steps {
+			if step == StepInsert {
+				continue
+			}
+			document, f := w.nextRead()
+			if document == nil {
+				// put back what has been taken so far
+				for i, d := range documents {
+					if fresh[i] {
+						w.q.Enqueue(*d)
+					}
+				}
+				return nil, nil
+			}
+			documents = append(documents, document)
+			fresh = append(fresh, f)
+		}
+		return documents, fresh
+	}
+	if t.Contention == 0 {
+		return take()
+	}
+
+	group := w.index / t.Contention
+	t.mu.Lock()
+	shared, ok := t.groups[group]
+	t.mu.Unlock()
+	if ok {
+		return shared, make([]bool, len(shared))
+	}
+
+	// take outside of the lock; nextRead may block on the queue
+	documents, fresh = take()
+	if documents == nil {
+		return nil, nil
+	}
+	t.mu.Lock()
+	shared, ok = t.groups[group]
+	if !ok {
+		t.groups[group] = documents
+		shared = documents
+	}
+	t.mu.Unlock()
+	if ok {
+		// another worker of the group got there first
+		for i, d := range documents {
+			if fresh[i] {
+				w.q.Enqueue(*d)
+			}
+		}
+	}
+	return shared, make([]bool, len(shared))
+}
+
+// transaction runs the configured steps inside a transaction.  Documents and
+// updates are taken from the generators before the transaction starts so a
+// retried transaction writes the same values.
+func (w *worker) transaction() bool {
+	opts := w.opts.Transactions
+	documents, fresh := opts.documents(w)
+	if documents == nil {
+		return false
+	}
+	// the documents are left in the collection whatever the outcome, so
+	// fresh ids go back on the queue on every return
+	defer func() {
+		for i, document := range documents {
+			if fresh[i] {
+				w.q.Enqueue(*document)
+			}
+		}
+	}()
+
+	steps := make([]TransactionStep, 0, len(opts.Steps))
+	var inserted []string
+	next := 0
+	for _, kind := range opts.Steps {
+		step := TransactionStep{Kind: kind}
+		switch kind {
+		case StepInsert:
+			document := w.nextDocument()
+			if document.Body == nil {
+				return false
+			}
+			body, id, err := withID(document.Body)
+			if err != nil {
+				w.l.WithField("error", err).Error("could not add an _id to a document")
+				return false
+			}
+			step.Document = body
+			if id != "" {
+				inserted = append(inserted, id)
+			}
+		default:
+			step.ID = documents[next].Id
+			next++
+		}
+		switch kind {
+		case StepUpdate:
+			update := w.nextUpdate()
+			if update.Body == nil {
+				return false
+			}
+			step.Document = update.Body
+		case StepReplace:
+			replacement := w.nextDocument()
+			if replacement.Body == nil {
+				return false
+			}
+			step.Document = replacement.Body
+		}
+		steps = append(steps, step)
+	}
+
+	start := w.start()
+	ok := w.m.RunTransaction(steps, opts)
+	w.observeLatency(OperationTransaction, start)
+	if !ok {
+		return true
+	}
+	for _, id := range inserted {
+		w.q.Enqueue(MongoDocument{
+			Id:        id,
+			Hostname:  w.hostname,
+			Timestamp: time.Now().UnixNano(),
+		})
+	}
+	return true
+}
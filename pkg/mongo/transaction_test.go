@@ -0,0 +1,166 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scbunn/mdbload/pkg/queue"
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestWorker returns a worker reading documents from q
+func newTestWorker(index int, q queue.Queue, opts *WorkerOptions) *worker {
+	return &worker{
+		index: index,
+		opts:  opts,
+		q:     q,
+		l:     log.WithField("worker", index),
+	}
+}
+
+// newTestQueue returns an in memory queue holding ids
+func newTestQueue(ids ...string) queue.Queue {
+	q := &queue.MemoryQueue{Registry: prometheus.NewRegistry()}
+	q.Init()
+	for _, id := range ids {
+		q.Enqueue(MongoDocument{Id: id})
+	}
+	return q
+}
+
+// queued drains q and returns the ids it held
+func queued(q queue.Queue) []string {
+	ids := []string{}
+	for item := q.Dequeue(); item != nil; item = q.Dequeue() {
+		ids = append(ids, item.(MongoDocument).Id)
+	}
+	return ids
+}
+
+func ids(documents []*MongoDocument) []string {
+	ids := []string{}
+	for _, d := range documents {
+		ids = append(ids, d.Id)
+	}
+	return ids
+}
+
+func TestParseTransactionSteps(t *testing.T) {
+	tests := map[string][]string{
+		"read,update":           {StepRead, StepUpdate},
+		" read , insert ,, ":    {StepRead, StepInsert},
+		"":                      {},
+		"replace,update,insert": {StepReplace, StepUpdate, StepInsert},
+	}
+	for s, want := range tests {
+		if got := ParseTransactionSteps(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestTransactionOptionsInit(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   *TransactionOptions
+		failed bool
+	}{
+		{"valid", &TransactionOptions{Steps: []string{StepRead, StepUpdate}, ReadConcern: "snapshot", WriteConcern: "majority"}, false},
+		{"numeric write concern", &TransactionOptions{Steps: []string{StepInsert}, ReadConcern: "local", WriteConcern: "1"}, false},
+		{"no steps", &TransactionOptions{ReadConcern: "snapshot", WriteConcern: "majority"}, true},
+		{"unknown step", &TransactionOptions{Steps: []string{"delete"}, ReadConcern: "snapshot", WriteConcern: "majority"}, true},
+		{"negative contention", &TransactionOptions{Steps: []string{StepRead}, ReadConcern: "snapshot", WriteConcern: "majority", Contention: -1}, true},
+		{"unknown read concern", &TransactionOptions{Steps: []string{StepRead}, ReadConcern: "linearizable", WriteConcern: "majority"}, true},
+		{"invalid write concern", &TransactionOptions{Steps: []string{StepRead}, ReadConcern: "snapshot", WriteConcern: "all"}, true},
+	}
+	for _, test := range tests {
+		if err := test.opts.Init(); (err != nil) != test.failed {
+			t.Errorf("%s: got error %v, want failure %v", test.name, err, test.failed)
+		}
+	}
+}
+
+func TestTransactionDocuments(t *testing.T) {
+	steps := []string{StepRead, StepInsert, StepUpdate}
+
+	// without contention every transaction takes its own documents
+	q := newTestQueue("a", "b", "c")
+	opts := &TransactionOptions{Steps: steps, ReadConcern: "snapshot", WriteConcern: "majority"}
+	if err := opts.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWorker(0, q, &WorkerOptions{Transactions: opts})
+	documents, fresh := opts.documents(w)
+	if got := ids(documents); !reflect.DeepEqual(got, []string{"a", "b"}) || !reflect.DeepEqual(fresh, []bool{true, true}) {
+		t.Errorf("got %v fresh %v, want [a b] fresh [true true]", got, fresh)
+	}
+	if got := queued(q); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("left %v on the queue, want [c]", got)
+	}
+
+	// nothing to read
+	if documents, _ := opts.documents(newTestWorker(0, newTestQueue(), &WorkerOptions{Transactions: opts})); documents != nil {
+		t.Errorf("got %v from an empty queue", ids(documents))
+	}
+}
+
+func TestTransactionContention(t *testing.T) {
+	q := newTestQueue("a", "b", "c", "d", "e")
+	opts := &TransactionOptions{Steps: []string{StepRead, StepUpdate}, ReadConcern: "snapshot", WriteConcern: "majority", Contention: 2}
+	if err := opts.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// workers 0 and 1 form the first group, worker 2 the second
+	want := [][]string{{"a", "b"}, {"a", "b"}, {"c", "d"}, {"a", "b"}}
+	for i, index := range []int{0, 1, 2, 0} {
+		w := newTestWorker(index, q, &WorkerOptions{Transactions: opts})
+		documents, fresh := opts.documents(w)
+		if got := ids(documents); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("worker %d: got %v, want %v", index, got, want[i])
+		}
+		// shared documents are never put back on the queue
+		for _, f := range fresh {
+			if f {
+				t.Errorf("worker %d: a shared document is fresh", index)
+			}
+		}
+	}
+	if got := queued(q); !reflect.DeepEqual(got, []string{"e"}) {
+		t.Errorf("left %v on the queue, want [e]", got)
+	}
+}
+
+func TestTransactionRequeue(t *testing.T) {
+	q := newTestQueue("a", "b")
+	opts := &TransactionOptions{Steps: []string{StepRead, StepUpdate}, ReadConcern: "snapshot", WriteConcern: "majority"}
+	if err := opts.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// the update generator has stopped without rendering anything
+	updates := make(chan Document)
+	close(updates)
+	w := newTestWorker(0, q, &WorkerOptions{Transactions: opts, Updates: updates})
+	if w.transaction() {
+		t.Fatal("a transaction without an update ran")
+	}
+	if got := queued(q); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("left %v on the queue, want [a b]", got)
+	}
+}
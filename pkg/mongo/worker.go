@@ -0,0 +1,428 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scbunn/mdbload/pkg/queue"
+	"github.com/scbunn/mdbload/pkg/report"
+	"github.com/scbunn/mdbload/pkg/workload"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Operations a worker can be asked to perform by a workload mix
+const (
+	OperationInsert  = "insert"
+	OperationRead    = "read"
+	OperationUpdate  = "update"
+	OperationReplace = "replace"
+	OperationDelete  = "delete"
+)
+
+// WorkerOptions holds everything a worker needs to generate load
+type WorkerOptions struct {
+	Mix          *workload.Mix
+	Scheduler    *workload.Scheduler      // when set operations are taken from the scheduler instead of the mix
+	Profile      *workload.Profile        // when set only the workers the current stage calls for are active
+	Documents    chan Document            // rendered documents for inserts and replaces
+	Updates      chan Document            // rendered update operator documents
+	Queries      map[string]chan Document // rendered query and pipeline templates by operation, e.g. query.by_name
+	Samples      *Samples                 // inserted documents queries draw their values from
+	Sampled      map[string]bool          // operations whose template has $sample placeholders
+	Bulk         *BulkOptions             // batches written by the bulk operation
+	Transactions *TransactionOptions      // steps of the transaction operation
+	Seed         int64                    // makes operation selection reproducible per worker; 0 for random
+
+	// Keys selects the ids read, updated and replaced from the pool of
+	// written ids, which requires a queue.Store.  nil consumes the queue in
+	// the order the ids were written.
+	Keys workload.KeySelector
+
+	// WaitForDocuments makes workers wait for a freshly rendered document
+	// instead of reusing the last one, applying backpressure from the
+	// generator
+	WaitForDocuments bool
+}
+
+// ValidateMix returns an error if the mix contains an operation the worker
+// does not know how to perform.
+func ValidateMix(mix *workload.Mix) error {
+	for _, item := range mix.Items() {
+		switch item.Name {
+		case OperationInsert, OperationRead, OperationUpdate, OperationReplace, OperationDelete, OperationBulk, OperationTransaction:
+		default:
+			if QueryName(item.Name) != "" || PipelineName(item.Name) != "" {
+				continue
+			}
+			return fmt.Errorf("unknown operation %q in workload mix", item.Name)
+		}
+	}
+	return nil
+}
+
+// worker holds the state of a single load generation goroutine
+type worker struct {
+	index    int
+	m        *MongoLoad
+	opts     *WorkerOptions
+	q        queue.Queue
+	rng      *rand.Rand
+	hostname string
+	document Document            // the last document received from the generator
+	update   Document            // the last update received from the generator
+	queries  map[string]Document // the last query or pipeline received from each generator
+	read     *MongoDocument      // the last document taken from the queue
+	intended time.Time           // intended start of the current operation when rate limited
+	recorder *report.Recorder
+	l        *log.Entry
+}
+
+// WorkerRoutine performs operations until the test duration has expired.
+//
+// Without a scheduler every iteration selects a single operation from the
+// mix by weight so the ratio between operation types holds regardless of how
+// long each type takes to complete.  With a scheduler the worker performs
+// the operations it is handed, as they are handed out, until the scheduler
+// stops.
+//
+// index is the position of the worker in the pool; a concurrency profile
+// keeps workers with an index at or above the current target idle.
+//
+// Cancelling ctx stops the worker once its in flight operation has completed.
+func (m *MongoLoad) WorkerRoutine(ctx context.Context, index int, opts *WorkerOptions, waitGroup *sync.WaitGroup) {
+	defer waitGroup.Done()
+	hostname, _ := os.Hostname()
+	rng := workload.NewRand(opts.Seed, index)
+	w := worker{
+		index:    index,
+		m:        m,
+		opts:     opts,
+		q:        *m.queue,
+		rng:      rng,
+		hostname: hostname,
+		queries:  make(map[string]Document),
+		recorder: m.options.Recorder.NewChild(),
+		l: log.WithFields(log.Fields{
+			"goroutineID": workload.NewUUID(rng),
+		}),
+	}
+
+	if opts.Scheduler != nil {
+		w.l.Info("starting rate limited worker")
+		for {
+			ticket, ok := opts.Scheduler.Next()
+			if !ok {
+				w.l.Debug("exiting due to scheduler stop")
+				return
+			}
+			w.intended = ticket.Intended
+			w.perform(ticket.Operation)
+		}
+	}
+
+	w.l.WithField("mix", opts.Mix.String()).Info("starting worker")
+	timeout := time.After(m.options.TestDuration)
+	for {
+		select {
+		case <-timeout: // duration has elapsed, exit
+			w.l.Debug("exiting due to timeout")
+			return
+		case <-ctx.Done(): // asked to shutdown, exit
+			w.l.Debug("exiting due to shutdown")
+			return
+		default: // don't block until timeout
+		}
+
+		if opts.Profile != nil && index >= opts.Profile.Concurrency(opts.Profile.Elapsed()) {
+			// not needed by the current stage
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if !w.perform(opts.Mix.Pick(w.rng)) {
+			// nothing has been written yet; give the writers a moment
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// perform a single operation.  false is returned if there was nothing to
+// operate on.
+func (w *worker) perform(operation string) bool {
+	switch operation {
+	case OperationInsert:
+		return w.insert()
+	case OperationRead:
+		return w.readOne()
+	case OperationUpdate:
+		return w.updateOne()
+	case OperationReplace:
+		return w.replaceOne()
+	case OperationDelete:
+		return w.deleteOne()
+	case OperationBulk:
+		return w.bulk()
+	case OperationTransaction:
+		return w.transaction()
+	}
+	if name := QueryName(operation); name != "" {
+		return w.query(operation, func(rendered interface{}, sample bson.Raw) bool {
+			return w.m.RunQuery(name, rendered, sample)
+		})
+	}
+	if name := PipelineName(operation); name != "" {
+		return w.query(operation, func(rendered interface{}, sample bson.Raw) bool {
+			return w.m.RunAggregation(name, rendered, sample)
+		})
+	}
+	return false
+}
+
+// observeLatency records the latency of an operation into the worker's own
+// recorder
+func (w *worker) observeLatency(operation string, start time.Time) {
+	observeLatency(w.recorder, operation, start)
+}
+
+// recordDocumentSize records the BSON size of a document about to be
+// inserted into the worker's own recorder
+func (w *worker) recordDocumentSize(template string, body interface{}) {
+	// TODO: this feels heavy, find a better way
+	b, _ := bson.Marshal(body)
+	documentSize.WithLabelValues(template).Observe(float64(len(b)))
+	w.recorder.RecordDocumentSize(len(b))
+}
+
+// start returns the time an operation should be measured from.  Rate limited
+// operations are measured from their intended start so time spent waiting
+// for a worker counts against the operation.
+func (w *worker) start() time.Time {
+	if !w.intended.IsZero() {
+		return w.intended
+	}
+	return time.Now()
+}
+
+// nextDocument returns a new document from the generator if there is one,
+// otherwise the last document received is reused.  The first call blocks
+// until a document is available.
+func (w *worker) nextDocument() Document {
+	w.document = w.next("document", w.opts.Documents, w.document)
+	return w.document
+}
+
+// nextUpdate returns a new update document from the generator if there is
+// one, otherwise the last update received is reused.
+func (w *worker) nextUpdate() Document {
+	w.update = w.next("update", w.opts.Updates, w.update)
+	return w.update
+}
+
+// next returns an empty document if the generator has stopped before
+// producing anything.  Unless the worker waits for documents, reusing the
+// last document is counted as a stale reuse.
+func (w *worker) next(generator string, c chan Document, last Document) Document {
+	select {
+	case document, ok := <-c:
+		if ok {
+			w.l.Debug("got a new document")
+			return document
+		}
+		return last // the generator has stopped
+	default:
+	}
+
+	if last.Body != nil && !w.opts.WaitForDocuments {
+		documentReuse.WithLabelValues(generator).Inc()
+		return last
+	}
+	start := time.Now()
+	document, ok := <-c
+	generatorWait.WithLabelValues(generator).Observe(time.Since(start).Seconds())
+	if !ok {
+		return last
+	}
+	return document
+}
+
+// nextRead returns the next document to operate on from the queue.  If the
+// queue is empty the last document taken is reused and fresh is false.  nil
+// is returned when no document has been queued yet.
+//
+// With a key selector the document is sampled from the queue without
+// removing it, so fresh is always false.
+func (w *worker) nextRead() (document *MongoDocument, fresh bool) {
+	if w.opts.Keys != nil {
+		return w.sampleRead(), false
+	}
+	if item := w.q.Dequeue(); item != nil {
+		if document, ok := w.m.stringToMongoDocument(item); ok {
+			w.read = document
+			return w.read, true
+		}
+	}
+	if w.read != nil {
+		w.l.WithFields(log.Fields{
+			"id": w.read.Id,
+		}).Debug("no item in queue, using old document")
+	}
+	return w.read, false
+}
+
+// sampleRead picks a document from the pool of written ids with the key
+// selector
+func (w *worker) sampleRead() *MongoDocument {
+	store := w.q.(queue.Store)
+	n := store.Size()
+	if n <= 0 {
+		return w.read
+	}
+	if item := store.Get(w.opts.Keys.Next(w.rng, n)); item != nil {
+		if document, ok := w.m.stringToMongoDocument(item); ok {
+			w.read = document
+		}
+	}
+	return w.read
+}
+
+func (w *worker) insert() bool {
+	document := w.nextDocument()
+	if document.Body == nil {
+		return false
+	}
+	w.recordDocumentSize(document.Template, document.Body)
+	start := w.start()
+	id, ok := w.m.InsertDocument(document)
+	w.observeLatency(OperationInsert, start)
+	if !ok {
+		w.l.WithFields(log.Fields{
+			"ok":       ok,
+			"id":       id,
+			"instance": w.hostname,
+		}).Error("failed to insert document")
+		return true // don't enqueue a failed insert
+	}
+	if w.opts.Samples != nil {
+		w.opts.Samples.Add(document.Body)
+	}
+	if id == "" {
+		return true // the _id is not an ObjectID and cannot be read back
+	}
+	w.q.Enqueue(MongoDocument{
+		Id:        id,
+		Hostname:  w.hostname,
+		Timestamp: time.Now().UnixNano(),
+	})
+	return true
+}
+
+func (w *worker) readOne() bool {
+	document, _ := w.nextRead()
+	if document == nil {
+		return false
+	}
+	start := w.start()
+	w.m.ReadDocument(document.Id)
+	w.observeLatency(OperationRead, start)
+	return true
+}
+
+// updated and replaced documents taken fresh from the queue are always put
+// back so they can be used again, whatever the outcome of the write
+func (w *worker) updateOne() bool {
+	update := w.nextUpdate()
+	if update.Body == nil {
+		return false
+	}
+	document, fresh := w.nextRead()
+	if document == nil {
+		return false
+	}
+	if fresh {
+		defer w.q.Enqueue(*document)
+	}
+	start := w.start()
+	w.m.UpdateDocument(document.Id, update.Body)
+	w.observeLatency(OperationUpdate, start)
+	return true
+}
+
+func (w *worker) replaceOne() bool {
+	replacement := w.nextDocument()
+	if replacement.Body == nil {
+		return false
+	}
+	document, fresh := w.nextRead()
+	if document == nil {
+		return false
+	}
+	if fresh {
+		defer w.q.Enqueue(*document)
+	}
+	start := w.start()
+	w.m.ReplaceDocument(document.Id, replacement.Body)
+	w.observeLatency(OperationReplace, start)
+	return true
+}
+
+// query runs the next rendered query or pipeline of an operation.  Their
+// $sample placeholders are filled in from a random inserted document; a
+// template with placeholders is skipped until something has been inserted.
+func (w *worker) query(operation string, run func(rendered interface{}, sample bson.Raw) bool) bool {
+	var sample bson.Raw
+	if w.opts.Sampled[operation] {
+		if sample = w.opts.Samples.Pick(w.rng); sample == nil {
+			sampleMissing.WithLabelValues(operation).Inc()
+			w.l.WithField("operation", operation).Debug("nothing inserted to sample yet, skipping")
+			return false
+		}
+	}
+	w.queries[operation] = w.next(operation, w.opts.Queries[operation], w.queries[operation])
+	rendered := w.queries[operation]
+	if rendered.Body == nil {
+		return false
+	}
+	start := w.start()
+	run(rendered.Body, sample)
+	w.observeLatency(operation, start)
+	return true
+}
+
+// deletes only use fresh items from the queue and never put them back
+func (w *worker) deleteOne() bool {
+	item := w.q.Dequeue()
+	if item == nil {
+		return false
+	}
+	document, ok := w.m.stringToMongoDocument(item)
+	if !ok {
+		return true
+	}
+	if w.read != nil && w.read.Id == document.Id {
+		w.read = nil
+	}
+	start := w.start()
+	w.m.DeleteDocument(document.Id)
+	w.observeLatency(OperationDelete, start)
+	return true
+}
@@ -0,0 +1,41 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWriteOneKeepsQueue(t *testing.T) {
+	// the generators have stopped without rendering anything
+	closed := make(chan Document)
+	close(closed)
+	opts := &WorkerOptions{Documents: closed, Updates: closed}
+	tests := map[string]func(w *worker) bool{
+		"update":  (*worker).updateOne,
+		"replace": (*worker).replaceOne,
+	}
+	for name, run := range tests {
+		q := newTestQueue("a", "b")
+		if run(newTestWorker(0, q, opts)) {
+			t.Errorf("%s: ran without a rendered body", name)
+		}
+		if got := queued(q); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("%s: left %v on the queue, want [a b]", name, got)
+		}
+	}
+}
@@ -24,8 +24,9 @@ import (
 
 // MemoryQueue is an in-memory FIFO queue implementing the Queue interface
 type MemoryQueue struct {
-	queue    *lane.Queue
-	Registry *prometheus.Registry
+	queue          *lane.Queue
+	Registry       *prometheus.Registry
+	LatencyBuckets []float64 // queue latency histogram buckets (seconds)
 }
 
 // Init initializes a new in memory queue
@@ -33,9 +34,7 @@ func (q *MemoryQueue) Init() bool {
 	if q.queue == nil {
 		q.queue = lane.NewQueue()
 	}
-	q.Registry.MustRegister(queueLatency)
-	q.Registry.MustRegister(queueSize)
-	q.Registry.MustRegister(queueError)
+	registerMetrics(q.Registry, q.LatencyBuckets)
 	return true
 }
 
@@ -0,0 +1,98 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MemoryStore is an in-memory Store.  Dequeue removes the oldest item.
+type MemoryStore struct {
+	mu             sync.RWMutex
+	items          []interface{}
+	head           int // position of the oldest item in items
+	Registry       *prometheus.Registry
+	LatencyBuckets []float64 // queue latency histogram buckets (seconds)
+}
+
+// Init initializes a new in memory store
+func (q *MemoryStore) Init() bool {
+	registerMetrics(q.Registry, q.LatencyBuckets)
+	return true
+}
+
+// Enqueue adds a new item to the store
+func (q *MemoryStore) Enqueue(item interface{}) {
+	start := time.Now()
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+	queueLatency.WithLabelValues("enqueue").Observe(time.Since(start).Seconds())
+	queueSize.Inc()
+}
+
+// Dequeue removes and returns the oldest item in the store
+func (q *MemoryStore) Dequeue() interface{} {
+	start := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.head == len(q.items) {
+		return nil
+	}
+	item := q.items[q.head]
+	q.items[q.head] = nil
+	q.head++
+	// reclaim the removed items once they make up half of the slice
+	if q.head > len(q.items)/2 {
+		q.items = append([]interface{}(nil), q.items[q.head:]...)
+		q.head = 0
+	}
+	queueLatency.WithLabelValues("dequeue").Observe(time.Since(start).Seconds())
+	queueSize.Dec()
+	return item
+}
+
+// Get returns item i, 0 being the oldest, without removing it
+func (q *MemoryStore) Get(i int) interface{} {
+	start := time.Now()
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if i < 0 || q.head+i >= len(q.items) {
+		return nil
+	}
+	queueLatency.WithLabelValues("get").Observe(time.Since(start).Seconds())
+	return q.items[q.head+i]
+}
+
+// Head returns the oldest item in the store
+func (q *MemoryStore) Head() interface{} {
+	return q.Get(0)
+}
+
+// Size returns the number of items in the store
+func (q *MemoryStore) Size() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.items) - q.head
+}
+
+// Empty returns true if the store is empty; false otherwise
+func (q *MemoryStore) Empty() bool {
+	return q.Size() == 0
+}
@@ -29,15 +29,16 @@ type Queue interface {
 	Init() bool
 }
 
+// Store is a Queue whose items can also be read by position without removing
+// them, so reads can sample the pool of written ids
+type Store interface {
+	Queue
+	Get(i int) interface{} // item i, 0 being the oldest; nil if out of range
+}
+
 var (
-	queueLatency = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Namespace: "mdbload",
-			Name:      "queue_latency_seconds",
-			Help:      "Latency of queue operations",
-		},
-		[]string{"operation"},
-	)
+	// created when a queue is initialized so the buckets can be configured
+	queueLatency *prometheus.HistogramVec
 
 	queueSize = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -56,3 +57,21 @@ var (
 		[]string{"operation"},
 	)
 )
+
+// registerMetrics creates the queue latency histogram with the given buckets
+// and registers every queue metric.  nil buckets uses the prometheus default
+// buckets.
+func registerMetrics(registry *prometheus.Registry, buckets []float64) {
+	queueLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "mdbload",
+			Name:      "queue_latency_seconds",
+			Help:      "Latency of queue operations",
+			Buckets:   buckets,
+		},
+		[]string{"operation"},
+	)
+	registry.MustRegister(queueLatency)
+	registry.MustRegister(queueSize)
+	registry.MustRegister(queueError)
+}
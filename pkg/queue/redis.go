@@ -24,27 +24,52 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultRedisKey is the redis key of the queue when none is configured
+const DefaultRedisKey = "mdbload:queue"
+
 // RedisQueue is a distributed FIFO queue using Redis
 type RedisQueue struct {
-	client   *redis.Client
-	key      string
-	Registry *prometheus.Registry
-	Server   string
+	client         *redis.Client
+	Key            string // defaults to DefaultRedisKey
+	Registry       *prometheus.Registry
+	LatencyBuckets []float64 // queue latency histogram buckets (seconds)
+	Server         string
 }
 
 // Init initializes a new RedisQueue
 func (q *RedisQueue) Init() bool {
+	q.connect()
+	registerMetrics(q.Registry, q.LatencyBuckets)
+
+	return true
+}
+
+func (q *RedisQueue) connect() {
 	if q.client == nil {
 		q.client = redis.NewClient(&redis.Options{
 			Addr: q.Server,
 		})
 	}
-	q.key = "mdbload:queue"
-	q.Registry.MustRegister(queueLatency)
-	q.Registry.MustRegister(queueSize)
-	q.Registry.MustRegister(queueError)
+	if q.Key == "" {
+		q.Key = DefaultRedisKey
+	}
+}
 
-	return true
+// Purge deletes the queue and returns the number of items it held.  If dryRun
+// is true the items are only counted.  Purge does not require Init.
+func (q *RedisQueue) Purge(dryRun bool) (int, error) {
+	q.connect()
+	count, err := q.client.LLen(q.Key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if dryRun || count == 0 {
+		return int(count), nil
+	}
+	if err := q.client.Del(q.Key).Err(); err != nil {
+		return 0, err
+	}
+	return int(count), nil
 }
 
 // Enqueue adds a new item to the queue
@@ -56,7 +81,7 @@ func (q *RedisQueue) Enqueue(item interface{}) {
 		log.Error(err)
 		return
 	}
-	if err = q.client.RPush(q.key, string(i)).Err(); err != nil {
+	if err = q.client.RPush(q.Key, string(i)).Err(); err != nil {
 		log.Error(err)
 		queueError.WithLabelValues("enqueue").Inc()
 		return
@@ -68,11 +93,11 @@ func (q *RedisQueue) Enqueue(item interface{}) {
 // Dequeue pop the tail off the queue and returns it
 func (q *RedisQueue) Dequeue() interface{} {
 	start := time.Now()
-	item, err := q.client.BLPop(1*time.Second, q.key).Result()
+	item, err := q.client.BLPop(1*time.Second, q.Key).Result()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"key":   q.key,
+			"key":   q.Key,
 			"item":  item,
 		}).Error("error getting an item from the queue.")
 		queueError.WithLabelValues("dequeue").Inc()
@@ -83,9 +108,30 @@ func (q *RedisQueue) Dequeue() interface{} {
 	return item[1]
 }
 
+// Get returns item i, 0 being the oldest, without modifying the queue.  Redis
+// walks the list from its nearest end, so reads far from either end of a long
+// queue are slow.
+func (q *RedisQueue) Get(i int) interface{} {
+	start := time.Now()
+	item, err := q.client.LIndex(q.Key, int64(i)).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"key":   q.Key,
+		}).Error("error getting an item from the queue.")
+		queueError.WithLabelValues("get").Inc()
+		return nil
+	}
+	queueLatency.WithLabelValues("get").Observe(time.Since(start).Seconds())
+	return item
+}
+
 // Size returns the approximate number of elements in the queue
 func (q *RedisQueue) Size() int {
-	count, err := q.client.LLen(q.key).Result()
+	count, err := q.client.LLen(q.Key).Result()
 	if err != nil {
 		log.Error(err)
 		return -1
@@ -100,7 +146,7 @@ func (q *RedisQueue) Empty() bool {
 
 // Head returns the left most item in the queue without modifying the queue
 func (q *RedisQueue) Head() interface{} {
-	item, err := q.client.LRange(q.key, 0, 0).Result()
+	item, err := q.client.LRange(q.Key, 0, 0).Result()
 	if err != nil {
 		log.Error(err)
 		return nil
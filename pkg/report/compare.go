@@ -0,0 +1,227 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+)
+
+// Comparison formats
+const (
+	FormatMarkdown = "markdown"
+)
+
+// Status of a compared metric
+const (
+	DeltaOK        = "ok"
+	DeltaImproved  = "improved"
+	DeltaRegressed = "regressed"
+	DeltaChanged   = "changed" // beyond tolerance in a metric that is neither better nor worse
+	DeltaMissing   = "missing" // in the baseline but not in the current report
+	DeltaAdded     = "added"   // in the current report but not in the baseline
+)
+
+// Tolerances are the changes allowed before a metric is flagged.  Latency,
+// throughput and document size tolerances are relative (0.1 is 10%); the error
+// rate tolerance is absolute (0.001 is 0.1 percentage points) because the
+// baseline error rate is usually zero.
+type Tolerances struct {
+	Latency      float64
+	Throughput   float64
+	ErrorRate    float64
+	DocumentSize float64
+}
+
+// Delta is the change of a single metric between two reports
+type Delta struct {
+	Operation string  `json:"operation"`
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Current   float64 `json:"current"`
+	Change    float64 `json:"change"` // relative, or absolute for error rates
+	Status    string  `json:"status"`
+}
+
+// Comparison is the difference between a baseline and a current report
+type Comparison struct {
+	Deltas []Delta `json:"deltas"`
+}
+
+// direction of a metric when it gets worse
+const (
+	higherIsWorse = iota
+	lowerIsWorse
+	neither
+)
+
+// ReadReport reads a JSON report
+func ReadReport(r io.Reader) (*Report, error) {
+	report := Report{}
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Compare compares every operation of the baseline with the current report
+// and flags changes beyond the tolerances
+func Compare(baseline, current *Report, t Tolerances) *Comparison {
+	c := Comparison{}
+	for _, base := range baseline.Operations {
+		op, ok := current.Operation(base.Name)
+		if !ok {
+			c.Deltas = append(c.Deltas, Delta{Operation: base.Name, Metric: "operation", Status: DeltaMissing})
+			continue
+		}
+		c.relative(base.Name, MetricThroughput, base.Throughput, op.Throughput, t.Throughput, lowerIsWorse)
+		c.relative(base.Name, MetricP50, base.Latency.P50, op.Latency.P50, t.Latency, higherIsWorse)
+		c.relative(base.Name, MetricP90, base.Latency.P90, op.Latency.P90, t.Latency, higherIsWorse)
+		c.relative(base.Name, MetricP99, base.Latency.P99, op.Latency.P99, t.Latency, higherIsWorse)
+		c.relative(base.Name, MetricP999, base.Latency.P999, op.Latency.P999, t.Latency, higherIsWorse)
+
+		d := Delta{
+			Operation: base.Name,
+			Metric:    MetricErrorRate,
+			Baseline:  base.ErrorRate,
+			Current:   op.ErrorRate,
+			Change:    op.ErrorRate - base.ErrorRate,
+		}
+		d.Status = status(d.Change, t.ErrorRate, higherIsWorse)
+		c.Deltas = append(c.Deltas, d)
+	}
+	for _, op := range current.Operations {
+		if _, ok := baseline.Operation(op.Name); !ok {
+			c.Deltas = append(c.Deltas, Delta{Operation: op.Name, Metric: "operation", Status: DeltaAdded})
+		}
+	}
+
+	if baseline.DocumentSize.Count > 0 && current.DocumentSize.Count > 0 {
+		c.relative("document_size", MetricMean, baseline.DocumentSize.Mean, current.DocumentSize.Mean, t.DocumentSize, neither)
+		c.relative("document_size", MetricP99, baseline.DocumentSize.P99, current.DocumentSize.P99, t.DocumentSize, neither)
+	}
+	return &c
+}
+
+// relative adds the relative change of a metric
+func (c *Comparison) relative(operation, metric string, baseline, current, tolerance float64, worse int) {
+	d := Delta{
+		Operation: operation,
+		Metric:    metric,
+		Baseline:  baseline,
+		Current:   current,
+	}
+	switch {
+	case baseline != 0:
+		d.Change = (current - baseline) / baseline
+	case current != 0:
+		d.Change = 1 // treated as doubling
+	}
+	d.Status = status(d.Change, tolerance, worse)
+	c.Deltas = append(c.Deltas, d)
+}
+
+func status(change, tolerance float64, worse int) string {
+	if math.Abs(change) <= tolerance {
+		return DeltaOK
+	}
+	switch {
+	case worse == neither:
+		return DeltaChanged
+	case (change > 0) == (worse == higherIsWorse):
+		return DeltaRegressed
+	}
+	return DeltaImproved
+}
+
+// Regressed returns true if any metric regressed or an operation is missing
+func (c *Comparison) Regressed() bool {
+	for _, d := range c.Deltas {
+		if d.Status == DeltaRegressed || d.Status == DeltaMissing {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the comparison to w in the given format
+func (c *Comparison) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatText:
+		return c.WriteText(w)
+	case FormatMarkdown:
+		return c.WriteMarkdown(w)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	}
+	return fmt.Errorf("unknown comparison format %q", format)
+}
+
+// WriteText writes the comparison as a table
+func (c *Comparison) WriteText(w io.Writer) error {
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(t, "operation\tmetric\tbaseline\tcurrent\tchange\tstatus\t")
+	for _, d := range c.Deltas {
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\t\n", d.Operation, d.Metric, d.value(d.Baseline), d.value(d.Current), d.change(), d.Status)
+	}
+	return t.Flush()
+}
+
+// WriteMarkdown writes the comparison as a Markdown table, e.g. for a pull
+// request comment
+func (c *Comparison) WriteMarkdown(w io.Writer) error {
+	fmt.Fprintln(w, "| operation | metric | baseline | current | change | status |")
+	fmt.Fprintln(w, "|---|---|---:|---:|---:|---|")
+	for _, d := range c.Deltas {
+		status := d.Status
+		if status == DeltaRegressed || status == DeltaMissing {
+			status = "**" + status + "**"
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n", d.Operation, d.Metric, d.value(d.Baseline), d.value(d.Current), d.change(), status)
+	}
+	return nil
+}
+
+func (d *Delta) value(v float64) string {
+	switch {
+	case d.Status == DeltaMissing || d.Status == DeltaAdded:
+		return ""
+	case d.Metric == MetricErrorRate:
+		return fmt.Sprintf("%.3f%%", v*100)
+	case d.Metric == MetricThroughput:
+		return fmt.Sprintf("%.1f/s", v)
+	case d.Operation == "document_size":
+		return fmt.Sprintf("%.0fB", v)
+	}
+	return fmt.Sprintf("%.2fms", v)
+}
+
+func (d *Delta) change() string {
+	switch {
+	case d.Status == DeltaMissing || d.Status == DeltaAdded:
+		return ""
+	case d.Metric == MetricErrorRate:
+		return fmt.Sprintf("%+.3fpp", d.Change*100)
+	case d.Baseline == 0 && d.Current != 0:
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", d.Change*100)
+}
@@ -0,0 +1,144 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	baseline := &Report{
+		Operations: []Operation{
+			{Name: "insert", Throughput: 1000, ErrorRate: 0, Latency: Distribution{P50: 2, P90: 4, P99: 10, P999: 20}},
+			{Name: "read", Throughput: 5000, ErrorRate: 0.001, Latency: Distribution{P50: 1, P90: 2, P99: 5, P999: 8}},
+		},
+	}
+	tolerances := Tolerances{Latency: 0.1, Throughput: 0.1, ErrorRate: 0.001, DocumentSize: 0.05}
+
+	tests := []struct {
+		name      string
+		current   []Operation
+		statuses  map[string]string // operation.metric:status; unlisted metrics are ok
+		regressed bool
+	}{
+		{
+			name:    "same",
+			current: baseline.Operations,
+		},
+		{
+			name: "within tolerance",
+			current: []Operation{
+				{Name: "insert", Throughput: 950, Latency: Distribution{P50: 2.1, P90: 4, P99: 10.5, P999: 20}},
+				{Name: "read", Throughput: 5400, ErrorRate: 0.0015, Latency: Distribution{P50: 1, P90: 2, P99: 5, P999: 8}},
+			},
+		},
+		{
+			name: "slower inserts",
+			current: []Operation{
+				{Name: "insert", Throughput: 800, Latency: Distribution{P50: 2, P90: 4, P99: 15, P999: 20}},
+				baseline.Operations[1],
+			},
+			statuses: map[string]string{
+				"insert.throughput": DeltaRegressed,
+				"insert.p99":        DeltaRegressed,
+			},
+			regressed: true,
+		},
+		{
+			name: "faster reads",
+			current: []Operation{
+				baseline.Operations[0],
+				{Name: "read", Throughput: 6000, Latency: Distribution{P50: 0.5, P90: 2, P99: 5, P999: 8}},
+			},
+			statuses: map[string]string{
+				"read.throughput": DeltaImproved,
+				"read.p50":        DeltaImproved,
+			},
+		},
+		{
+			name: "more errors",
+			current: []Operation{
+				baseline.Operations[0],
+				{Name: "read", Throughput: 5000, ErrorRate: 0.01, Latency: Distribution{P50: 1, P90: 2, P99: 5, P999: 8}},
+			},
+			statuses:  map[string]string{"read.error_rate": DeltaRegressed},
+			regressed: true,
+		},
+		{
+			name:      "missing operation",
+			current:   baseline.Operations[:1],
+			statuses:  map[string]string{"read.operation": DeltaMissing},
+			regressed: true,
+		},
+		{
+			name:     "added operation",
+			current:  append([]Operation{{Name: "update", Throughput: 10}}, baseline.Operations...),
+			statuses: map[string]string{"update.operation": DeltaAdded},
+		},
+	}
+	for _, test := range tests {
+		c := Compare(baseline, &Report{Operations: test.current}, tolerances)
+		for _, d := range c.Deltas {
+			want, ok := test.statuses[d.Operation+"."+d.Metric]
+			if !ok {
+				want = DeltaOK
+			}
+			if d.Status != want {
+				t.Errorf("%s: %s.%s is %s, want %s", test.name, d.Operation, d.Metric, d.Status, want)
+			}
+		}
+		// Regressed sets the exit status of the compare command
+		if got := c.Regressed(); got != test.regressed {
+			t.Errorf("%s: Regressed() = %v, want %v", test.name, got, test.regressed)
+		}
+	}
+}
+
+func TestCompareDocumentSize(t *testing.T) {
+	baseline := &Report{DocumentSize: Distribution{Count: 10, Mean: 1000, P99: 1200}}
+	current := &Report{DocumentSize: Distribution{Count: 10, Mean: 1500, P99: 1200}}
+	c := Compare(baseline, current, Tolerances{DocumentSize: 0.05})
+	if len(c.Deltas) != 2 || c.Deltas[0].Status != DeltaChanged || c.Deltas[1].Status != DeltaOK {
+		t.Errorf("got %+v, want a changed mean and an ok p99", c.Deltas)
+	}
+	// a change in size is neither better nor worse
+	if c.Regressed() {
+		t.Error("a document size change is reported as a regression")
+	}
+}
+
+func TestReadReport(t *testing.T) {
+	r := &Report{
+		Version:    "test",
+		Operations: []Operation{{Name: "insert", Count: 10, Throughput: 5, Latency: Distribution{P99: 3}}},
+	}
+	buf := new(bytes.Buffer)
+	if err := r.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadReport(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, ok := read.Operation("insert")
+	if !ok || op.Count != 10 || op.Throughput != 5 || op.Latency.P99 != 3 {
+		t.Errorf("got %+v, want the written operation", read.Operations)
+	}
+	if _, err := ReadReport(bytes.NewBufferString("not json")); err == nil {
+		t.Error("expected an error for an invalid report")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Supported report formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// ValidFormat returns true if format is a supported report format
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatText, FormatJSON, FormatCSV:
+		return true
+	}
+	return false
+}
+
+// Write writes the report to w in the given format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatText:
+		return r.WriteText(w)
+	case FormatJSON:
+		return r.WriteJSON(w)
+	case FormatCSV:
+		return r.WriteCSV(w)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// WriteJSON writes the report as an indented JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes the report as human readable tables
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "mdbload %s on %s\n", r.Version, r.Instance)
+	fmt.Fprintf(w, "status: %s\n", r.Status)
+	fmt.Fprintf(w, "duration: %.1fs (%s - %s)\n\n", r.Duration, r.Start.Format("15:04:05"), r.End.Format("15:04:05"))
+
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(t, "operation\tcount\terrors\tops/s\tmin\tmean\tp50\tp90\tp99\tp99.9\tmax (ms)\t")
+	for _, op := range r.Operations {
+		l := op.Latency
+		fmt.Fprintf(t, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			op.Name, op.Count, op.Errors, op.Throughput, l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+	}
+	if err := t.Flush(); err != nil {
+		return err
+	}
+
+	for _, op := range r.Operations {
+		if op.Documents > 0 {
+			fmt.Fprintf(w, "\n%s documents: %d (%.1f/s, %.1f per batch)\n",
+				op.Name, op.Documents, op.DocumentThroughput, float64(op.Documents)/float64(op.Count))
+		}
+	}
+	for _, op := range r.Operations {
+		if op.Errors > 0 {
+			fmt.Fprintf(w, "\n%s errors: %s\n", op.Name, errorClasses(op.ErrorClass, ", "))
+		}
+	}
+
+	if r.DroppedTickets > 0 {
+		fmt.Fprintf(w, "\ndropped tickets: %d scheduled operations were never picked up\n", r.DroppedTickets)
+	}
+
+	d := r.DocumentSize
+	if d.Count > 0 {
+		fmt.Fprintf(w, "\ndocument size (bytes): count %d min %.0f mean %.0f p50 %.0f p90 %.0f p99 %.0f p99.9 %.0f max %.0f\n",
+			d.Count, d.Min, d.Mean, d.P50, d.P90, d.P99, d.P999, d.Max)
+	}
+	return nil
+}
+
+// WriteCSV writes one row per operation plus a row for document sizes.  The
+// unit column tells the unit of the distribution columns; the documents
+// columns are only set for batched operations.
+func (r *Report) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"name", "unit", "count", "errors", "error_classes", "throughput", "min", "mean", "p50", "p90", "p99", "p99_9", "max", "documents", "document_throughput"})
+	for _, op := range r.Operations {
+		c.Write(append([]string{
+			op.Name,
+			"ms",
+			fmt.Sprint(op.Count),
+			fmt.Sprint(op.Errors),
+			errorClasses(op.ErrorClass, ";"),
+			fmt.Sprintf("%f", op.Throughput),
+		}, append(distributionFields(op.Latency), documentFields(op)...)...))
+	}
+	c.Write(append([]string{
+		"document_size",
+		"bytes",
+		fmt.Sprint(r.DocumentSize.Count),
+		"",
+		"",
+		"",
+	}, append(distributionFields(r.DocumentSize), "", "")...))
+	c.Flush()
+	return c.Error()
+}
+
+func distributionFields(d Distribution) []string {
+	fields := []string{}
+	for _, v := range []float64{d.Min, d.Mean, d.P50, d.P90, d.P99, d.P999, d.Max} {
+		fields = append(fields, fmt.Sprintf("%f", v))
+	}
+	return fields
+}
+
+func documentFields(op Operation) []string {
+	if op.Documents == 0 {
+		return []string{"", ""}
+	}
+	return []string{fmt.Sprint(op.Documents), fmt.Sprintf("%f", op.DocumentThroughput)}
+}
+
+// errorClasses formats error classes as class=count in sorted order
+func errorClasses(classes map[string]int64, separator string) string {
+	names := make([]string, 0, len(classes))
+	for class := range classes {
+		names = append(names, class)
+	}
+	sort.Strings(names)
+	fields := make([]string, 0, len(names))
+	for _, class := range names {
+		fields = append(fields, fmt.Sprintf("%s=%d", class, classes[class]))
+	}
+	return strings.Join(fields, separator)
+}
@@ -0,0 +1,109 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// histogramFile is the serialized form of a Recorder.  Histograms use the
+// compressed HdrHistogram V2 encoding so they can also be read by other HDR
+// tooling.
+type histogramFile struct {
+	Version      string                       `json:"version"`
+	Instance     string                       `json:"instance"`
+	Start        time.Time                    `json:"start"`
+	End          time.Time                    `json:"end"`
+	Operations   map[string]histogramFileData `json:"operations"`
+	DocumentSize []byte                       `json:"document_size_bytes"`
+}
+
+type histogramFileData struct {
+	Latency   []byte           `json:"latency_ns"`
+	Errors    map[string]int64 `json:"errors"`
+	Documents int64            `json:"documents,omitempty"`
+}
+
+// WriteHistograms serializes everything recorded by r, including its
+// children, so the histograms of several instances can be merged exactly
+// with ReadHistograms and Merge.
+func (r *Recorder) WriteHistograms(w io.Writer, version string, instance string) error {
+	snapshot := r.Snapshot()
+	f := histogramFile{
+		Version:    version,
+		Instance:   instance,
+		Start:      snapshot.start,
+		End:        snapshot.end,
+		Operations: make(map[string]histogramFileData),
+	}
+
+	var err error
+	for name, data := range snapshot.operations {
+		d := histogramFileData{
+			Errors:    data.errors,
+			Documents: data.documents,
+		}
+		if d.Latency, err = encode(data.latency); err != nil {
+			return fmt.Errorf("could not encode %s latency: %v", name, err)
+		}
+		f.Operations[name] = d
+	}
+	if f.DocumentSize, err = encode(snapshot.documentSize); err != nil {
+		return fmt.Errorf("could not encode document sizes: %v", err)
+	}
+	return json.NewEncoder(w).Encode(f)
+}
+
+// ReadHistograms reads a recorder written by WriteHistograms
+func ReadHistograms(rd io.Reader) (*Recorder, error) {
+	f := histogramFile{}
+	if err := json.NewDecoder(rd).Decode(&f); err != nil {
+		return nil, err
+	}
+
+	r := NewRecorder()
+	r.start = f.Start
+	r.end = f.End
+	for name, d := range f.Operations {
+		latency, err := hdrhistogram.Decode(d.Latency)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode %s latency: %v", name, err)
+		}
+		op := r.operation(name)
+		op.latency.Merge(latency)
+		for class, count := range d.Errors {
+			op.errors[class] += count
+		}
+		op.documents += d.Documents
+	}
+	if len(f.DocumentSize) > 0 {
+		size, err := hdrhistogram.Decode(f.DocumentSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode document sizes: %v", err)
+		}
+		r.documentSize.Merge(size)
+	}
+	return r, nil
+}
+
+func encode(h *hdrhistogram.Histogram) ([]byte, error) {
+	return h.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+}
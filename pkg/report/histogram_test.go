@@ -0,0 +1,83 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHistogramsMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances [][]time.Duration // insert latencies recorded by each instance
+		errors    int64             // insert errors recorded by each instance
+	}{
+		{"single", [][]time.Duration{{time.Millisecond, 2 * time.Millisecond}}, 1},
+		{"several", [][]time.Duration{{time.Millisecond}, {5 * time.Millisecond, 9 * time.Millisecond}, {3 * time.Millisecond}}, 2},
+	}
+	for _, test := range tests {
+		merged := NewRecorder()
+		var count int64
+		max := time.Duration(0)
+		for _, latencies := range test.instances {
+			r := NewRecorder()
+			child := r.NewChild()
+			for _, latency := range latencies {
+				child.RecordLatency("insert", latency)
+				if latency > max {
+					max = latency
+				}
+			}
+			for i := int64(0); i < test.errors; i++ {
+				r.RecordError("insert", "timeout")
+			}
+			child.RecordDocuments("bulk", 10)
+			count += int64(len(latencies))
+
+			buf := new(bytes.Buffer)
+			if err := r.WriteHistograms(buf, "test", "instance"); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			read, err := ReadHistograms(buf)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			merged.Merge(read)
+		}
+
+		report := New(merged, "test", "merged")
+		op, ok := report.Operation("insert")
+		if !ok {
+			t.Fatalf("%s: no insert operation in the merged report", test.name)
+		}
+		if op.Count != count {
+			t.Errorf("%s: count %d, want %d", test.name, op.Count, count)
+		}
+		if want := test.errors * int64(len(test.instances)); op.Errors != want || op.ErrorClass["timeout"] != want {
+			t.Errorf("%s: errors %d, want %d", test.name, op.Errors, want)
+		}
+		// HDR histograms keep 3 significant figures; allow a bucket of slack
+		if want := float64(max) / float64(time.Millisecond); op.Latency.Max < want*0.99 || op.Latency.Max > want*1.01 {
+			t.Errorf("%s: max %gms, want %gms", test.name, op.Latency.Max, want)
+		}
+		bulk, _ := report.Operation("bulk")
+		if want := int64(10 * len(test.instances)); bulk == nil || bulk.Documents != want {
+			t.Errorf("%s: bulk documents are not merged, want %d", test.name, want)
+		}
+	}
+}
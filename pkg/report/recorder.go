@@ -0,0 +1,211 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Histogram bounds.  Values outside of the bounds are clamped.
+const (
+	minLatency      = int64(time.Microsecond)
+	maxLatency      = int64(time.Minute)
+	minDocumentSize = 1
+	maxDocumentSize = 16 * 1024 * 1024 // largest BSON document mongo accepts
+	significantFigs = 3
+)
+
+// operationData holds everything recorded for a single operation
+type operationData struct {
+	latency   *hdrhistogram.Histogram // nanoseconds
+	errors    map[string]int64        // error class:count
+	documents int64                   // documents written by batched operations
+}
+
+// Recorder collects the raw data of a load test needed to build a Report.
+// It is fed alongside the prometheus metrics so the report and the metrics
+// agree.  A Recorder is safe for concurrent use.
+//
+// Latency is recorded into HDR histograms.  Hot paths such as load workers
+// should record into their own child recorder so they do not contend with
+// each other; children are merged whenever a report is built.
+type Recorder struct {
+	mu           sync.Mutex
+	start        time.Time
+	end          time.Time
+	operations   map[string]*operationData
+	documentSize *hdrhistogram.Histogram
+	children     []*Recorder
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		operations:   make(map[string]*operationData),
+		documentSize: hdrhistogram.New(minDocumentSize, maxDocumentSize, significantFigs),
+	}
+}
+
+// NewChild returns a new recorder that is merged into r when a report is
+// built.  Start and stop times are only tracked by r.
+func (r *Recorder) NewChild() *Recorder {
+	child := NewRecorder()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.children = append(r.children, child)
+	return child
+}
+
+// Merge adds everything recorded by other to r.  The merged test spans from
+// the earliest start to the latest end of both recorders.
+func (r *Recorder) Merge(other *Recorder) {
+	snapshot := other.Snapshot()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.merge(snapshot)
+}
+
+// Snapshot returns a copy of r with all of its children merged in
+func (r *Recorder) Snapshot() *Recorder {
+	snapshot := NewRecorder()
+	r.mu.Lock()
+	snapshot.merge(r)
+	children := r.children
+	r.mu.Unlock()
+
+	for _, child := range children {
+		child.mu.Lock()
+		snapshot.merge(child)
+		child.mu.Unlock()
+	}
+	return snapshot
+}
+
+// merge other into r.  The caller must hold the lock of both recorders.
+func (r *Recorder) merge(other *Recorder) {
+	if !other.start.IsZero() && (r.start.IsZero() || other.start.Before(r.start)) {
+		r.start = other.start
+	}
+	if other.end.After(r.end) {
+		r.end = other.end
+	}
+	for name, data := range other.operations {
+		op := r.operation(name)
+		op.latency.Merge(data.latency)
+		for class, count := range data.errors {
+			op.errors[class] += count
+		}
+		op.documents += data.documents
+	}
+	r.documentSize.Merge(other.documentSize)
+}
+
+// Reset discards everything recorded by r and its children.  It is used to
+// drop operations performed before the measured test starts.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.start = time.Time{}
+	r.end = time.Time{}
+	r.operations = make(map[string]*operationData)
+	r.documentSize.Reset()
+	children := r.children
+	r.mu.Unlock()
+
+	for _, child := range children {
+		child.Reset()
+	}
+}
+
+// Start marks the start of the measured test
+func (r *Recorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = time.Now()
+}
+
+// Stop marks the end of the measured test
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.end = time.Now()
+}
+
+// RecordLatency records the latency of a single operation
+func (r *Recorder) RecordLatency(operation string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operation(operation).latency.RecordValue(clamp(int64(latency), minLatency, maxLatency))
+}
+
+// RecordError records a failed operation by the class of its error
+func (r *Recorder) RecordError(operation string, class string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operation(operation).errors[class]++
+}
+
+// RecordDocuments records the documents written by a single batched
+// operation, such as a bulk write
+func (r *Recorder) RecordDocuments(operation string, documents int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operation(operation).documents += int64(documents)
+}
+
+// RecordDocumentSize records the size in bytes of a written document
+func (r *Recorder) RecordDocumentSize(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.documentSize.RecordValue(clamp(int64(size), minDocumentSize, maxDocumentSize))
+}
+
+// operations returns the names of all recorded operations in sorted order
+func (r *Recorder) operationNames() []string {
+	names := make([]string, 0, len(r.operations))
+	for name := range r.operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// operation returns the data of an operation, creating it if needed.  The
+// caller must hold the lock.
+func (r *Recorder) operation(name string) *operationData {
+	op, ok := r.operations[name]
+	if !ok {
+		op = &operationData{
+			latency: hdrhistogram.New(minLatency, maxLatency, significantFigs),
+			errors:  make(map[string]int64),
+		}
+		r.operations[name] = op
+	}
+	return op
+}
+
+func clamp(v, min, max int64) int64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,144 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Status of a load test
+const (
+	StatusCompleted   = "completed"
+	StatusInterrupted = "interrupted"
+	StatusAborted     = "aborted" // stopped early by a violated threshold
+)
+
+// Report is the summary of a load test
+type Report struct {
+	Version      string       `json:"version"`
+	Instance     string       `json:"instance"`
+	Status       string       `json:"status"`
+	Start        time.Time    `json:"start"`
+	End          time.Time    `json:"end"`
+	Duration     float64      `json:"duration_seconds"`
+	Operations   []Operation  `json:"operations"`
+	DocumentSize Distribution `json:"document_size_bytes"`
+
+	// operations a rate limited test scheduled but no worker picked up
+	// before it stopped
+	DroppedTickets int64 `json:"dropped_tickets,omitempty"`
+}
+
+// Operation summarizes every recorded instance of a single operation
+type Operation struct {
+	Name       string           `json:"name"`
+	Count      int64            `json:"count"`
+	Errors     int64            `json:"errors"`
+	ErrorRate  float64          `json:"error_rate"`
+	ErrorClass map[string]int64 `json:"error_classes"`
+	Throughput float64          `json:"throughput"` // ops per second
+	Latency    Distribution     `json:"latency_ms"`
+
+	// documents written by batched operations such as bulk writes
+	Documents          int64   `json:"documents,omitempty"`
+	DocumentThroughput float64 `json:"document_throughput,omitempty"` // documents per second
+}
+
+// Distribution describes the distribution of a recorded value
+type Distribution struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	P999  float64 `json:"p99_9"`
+	Max   float64 `json:"max"`
+}
+
+// Operation returns the named operation of the report
+func (r *Report) Operation(name string) (*Operation, bool) {
+	for i := range r.Operations {
+		if r.Operations[i].Name == name {
+			return &r.Operations[i], true
+		}
+	}
+	return nil, false
+}
+
+// New builds a report from everything recorded so far, including the
+// children of the recorder.  If the recorder was never stopped the report
+// ends now.
+func New(recorder *Recorder, version string, instance string) *Report {
+	r := recorder.Snapshot()
+
+	end := r.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	report := Report{
+		Version:      version,
+		Instance:     instance,
+		Status:       StatusCompleted,
+		Start:        r.start,
+		End:          end,
+		Duration:     end.Sub(r.start).Seconds(),
+		DocumentSize: distribution(r.documentSize, 1),
+	}
+
+	for _, name := range r.operationNames() {
+		data := r.operations[name]
+		op := Operation{
+			Name:       name,
+			Count:      data.latency.TotalCount(),
+			ErrorClass: make(map[string]int64),
+			Latency:    distribution(data.latency, float64(time.Millisecond)),
+			Documents:  data.documents,
+		}
+		for class, count := range data.errors {
+			op.ErrorClass[class] = count
+			op.Errors += count
+		}
+		if op.Count > 0 {
+			op.ErrorRate = float64(op.Errors) / float64(op.Count)
+		}
+		if report.Duration > 0 {
+			op.Throughput = float64(op.Count) / report.Duration
+			op.DocumentThroughput = float64(op.Documents) / report.Duration
+		}
+		report.Operations = append(report.Operations, op)
+	}
+	return &report
+}
+
+// distribution summarizes a histogram; values are divided by unit
+func distribution(h *hdrhistogram.Histogram, unit float64) Distribution {
+	if h.TotalCount() == 0 {
+		return Distribution{}
+	}
+	return Distribution{
+		Count: h.TotalCount(),
+		Min:   float64(h.Min()) / unit,
+		Mean:  h.Mean() / unit,
+		P50:   float64(h.ValueAtQuantile(50)) / unit,
+		P90:   float64(h.ValueAtQuantile(90)) / unit,
+		P99:   float64(h.ValueAtQuantile(99)) / unit,
+		P999:  float64(h.ValueAtQuantile(99.9)) / unit,
+		Max:   float64(h.Max()) / unit,
+	}
+}
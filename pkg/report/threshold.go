@@ -0,0 +1,233 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics a threshold can be set on
+const (
+	MetricMin        = "min"
+	MetricMean       = "mean"
+	MetricP50        = "p50"
+	MetricP90        = "p90"
+	MetricP99        = "p99"
+	MetricP999       = "p99.9"
+	MetricMax        = "max"
+	MetricCount      = "count"
+	MetricErrors     = "errors"
+	MetricErrorRate  = "error_rate"
+	MetricThroughput = "throughput"
+)
+
+// metrics are checked longest first so p99.9 is not mistaken for p99
+var metrics = []string{
+	MetricP999, MetricErrorRate, MetricThroughput, MetricErrors, MetricCount,
+	MetricMean, MetricP50, MetricP90, MetricP99, MetricMin, MetricMax,
+}
+
+var thresholdPattern = regexp.MustCompile(`^\s*(\S+)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+// Threshold is a pass/fail rule on a metric of an operation such as
+// "insert.p99 < 25ms", "read.error_rate < 0.1%" or
+// "insert.throughput > 3000/s".
+//
+// Latency values are in milliseconds unless they carry a duration unit,
+// error rates are fractions unless they end in %, and throughput is in
+// operations per second.
+type Threshold struct {
+	Rule       string
+	Operation  string
+	Metric     string
+	Comparator string
+	Value      float64
+}
+
+// ThresholdResult is the outcome of evaluating a threshold against a report
+type ThresholdResult struct {
+	Threshold *Threshold
+	Actual    float64
+	Passed    bool
+	Message   string
+	Count     int64 // operations the result is based on
+}
+
+// ParseThreshold parses a single threshold rule
+func ParseThreshold(rule string) (*Threshold, error) {
+	match := thresholdPattern.FindStringSubmatch(rule)
+	if match == nil {
+		return nil, fmt.Errorf("threshold %q is not in the form operation.metric <|<=|>|>= value", rule)
+	}
+	t := Threshold{
+		Rule:       strings.TrimSpace(rule),
+		Comparator: match[2],
+	}
+	for _, metric := range metrics {
+		if strings.HasSuffix(match[1], "."+metric) {
+			t.Operation = strings.TrimSuffix(match[1], "."+metric)
+			t.Metric = metric
+			break
+		}
+	}
+	if strings.HasSuffix(match[1], ".p999") {
+		t.Operation = strings.TrimSuffix(match[1], ".p999")
+		t.Metric = MetricP999
+	}
+	if t.Metric == "" || t.Operation == "" {
+		return nil, fmt.Errorf("threshold %q does not name an operation and a known metric", rule)
+	}
+
+	value, err := parseThresholdValue(t.Metric, match[3])
+	if err != nil {
+		return nil, fmt.Errorf("threshold %q: %v", rule, err)
+	}
+	t.Value = value
+	return &t, nil
+}
+
+// ParseThresholds parses a list of threshold rules
+func ParseThresholds(rules []string) ([]*Threshold, error) {
+	thresholds := []*Threshold{}
+	for _, rule := range rules {
+		t, err := ParseThreshold(rule)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+func parseThresholdValue(metric string, s string) (float64, error) {
+	switch metric {
+	case MetricErrorRate:
+		if strings.HasSuffix(s, "%") {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			return v / 100, err
+		}
+	case MetricThroughput:
+		s = strings.TrimSuffix(s, "/s")
+	case MetricCount, MetricErrors:
+	default: // latency
+		if d, err := time.ParseDuration(s); err == nil {
+			return float64(d) / float64(time.Millisecond), nil
+		}
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Continuous returns true if the threshold is meaningful while the test is
+// still running.  Throughput and operation counts only settle once the test
+// has finished.  Latency and error rate thresholds can recover as more
+// operations are recorded, so a violation is only a signal; see Final.
+func (t *Threshold) Continuous() bool {
+	switch t.Metric {
+	case MetricThroughput, MetricCount:
+		return false
+	case MetricErrors:
+		return t.Comparator == "<" || t.Comparator == "<="
+	}
+	return true
+}
+
+// Final returns true if a violation of the threshold can never recover, as
+// with an upper bound on the error count
+func (t *Threshold) Final() bool {
+	return t.Metric == MetricErrors && (t.Comparator == "<" || t.Comparator == "<=")
+}
+
+// Evaluate checks the threshold against a report
+func (t *Threshold) Evaluate(r *Report) ThresholdResult {
+	result := ThresholdResult{Threshold: t}
+	op, ok := r.Operation(t.Operation)
+	if !ok {
+		result.Message = fmt.Sprintf("%s: no %s operations were recorded", t.Rule, t.Operation)
+		return result
+	}
+	result.Actual = t.actual(op)
+	result.Count = op.Count
+
+	switch t.Comparator {
+	case "<":
+		result.Passed = result.Actual < t.Value
+	case "<=":
+		result.Passed = result.Actual <= t.Value
+	case ">":
+		result.Passed = result.Actual > t.Value
+	case ">=":
+		result.Passed = result.Actual >= t.Value
+	}
+	result.Message = fmt.Sprintf("%s: actual %s", t.Rule, t.format(result.Actual))
+	return result
+}
+
+func (t *Threshold) actual(op *Operation) float64 {
+	switch t.Metric {
+	case MetricMin:
+		return op.Latency.Min
+	case MetricMean:
+		return op.Latency.Mean
+	case MetricP50:
+		return op.Latency.P50
+	case MetricP90:
+		return op.Latency.P90
+	case MetricP99:
+		return op.Latency.P99
+	case MetricP999:
+		return op.Latency.P999
+	case MetricMax:
+		return op.Latency.Max
+	case MetricCount:
+		return float64(op.Count)
+	case MetricErrors:
+		return float64(op.Errors)
+	case MetricErrorRate:
+		return op.ErrorRate
+	case MetricThroughput:
+		return op.Throughput
+	}
+	return 0
+}
+
+func (t *Threshold) format(v float64) string {
+	switch t.Metric {
+	case MetricCount, MetricErrors:
+		return fmt.Sprintf("%.0f", v)
+	case MetricErrorRate:
+		return fmt.Sprintf("%.3f%%", v*100)
+	case MetricThroughput:
+		return fmt.Sprintf("%.1f/s", v)
+	}
+	return fmt.Sprintf("%.3fms", v)
+}
+
+// EvaluateThresholds checks every threshold against a report.  false is
+// returned if any threshold was violated.
+func EvaluateThresholds(r *Report, thresholds []*Threshold) ([]ThresholdResult, bool) {
+	results := []ThresholdResult{}
+	passed := true
+	for _, t := range thresholds {
+		result := t.Evaluate(r)
+		passed = passed && result.Passed
+		results = append(results, result)
+	}
+	return results, passed
+}
@@ -0,0 +1,137 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import "testing"
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		rule       string
+		operation  string
+		metric     string
+		comparator string
+		value      float64
+		failed     bool
+	}{
+		{rule: "insert.p99 < 25ms", operation: "insert", metric: MetricP99, comparator: "<", value: 25},
+		{rule: "insert.p99<25", operation: "insert", metric: MetricP99, comparator: "<", value: 25},
+		{rule: "read.p99.9 <= 1s", operation: "read", metric: MetricP999, comparator: "<=", value: 1000},
+		{rule: "read.p999 <= 500us", operation: "read", metric: MetricP999, comparator: "<=", value: 0.5},
+		{rule: "read.error_rate < 0.1%", operation: "read", metric: MetricErrorRate, comparator: "<", value: 0.001},
+		{rule: "read.error_rate < 0.01", operation: "read", metric: MetricErrorRate, comparator: "<", value: 0.01},
+		{rule: "insert.throughput > 3000/s", operation: "insert", metric: MetricThroughput, comparator: ">", value: 3000},
+		{rule: "query.by_id.count >= 10", operation: "query.by_id", metric: MetricCount, comparator: ">=", value: 10},
+		{rule: "update.errors < 1", operation: "update", metric: MetricErrors, comparator: "<", value: 1},
+		{rule: "insert.p99", failed: true},
+		{rule: "insert.p98 < 10", failed: true},
+		{rule: "p99 < 10", failed: true},
+		{rule: "insert.p99 = 10", failed: true},
+		{rule: "insert.p99 < fast", failed: true},
+		{rule: "insert.count > 10ms", failed: true},
+	}
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.rule)
+		if test.failed {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.rule, err)
+			continue
+		}
+		if threshold.Operation != test.operation || threshold.Metric != test.metric ||
+			threshold.Comparator != test.comparator || threshold.Value != test.value {
+			t.Errorf("%q: got %s.%s %s %g", test.rule, threshold.Operation, threshold.Metric, threshold.Comparator, threshold.Value)
+		}
+	}
+}
+
+func TestThresholdEvaluate(t *testing.T) {
+	r := &Report{
+		Operations: []Operation{
+			{
+				Name:       "insert",
+				Count:      1000,
+				Errors:     5,
+				ErrorRate:  0.005,
+				Throughput: 2500,
+				Latency:    Distribution{Count: 1000, P50: 2, P99: 20, Max: 80},
+			},
+		},
+	}
+	tests := []struct {
+		rule   string
+		actual float64
+		passed bool
+	}{
+		{"insert.p99 < 25ms", 20, true},
+		{"insert.p99 < 20ms", 20, false},
+		{"insert.p99 <= 20ms", 20, true},
+		{"insert.max > 100ms", 80, false},
+		{"insert.error_rate < 1%", 0.005, true},
+		{"insert.errors < 5", 5, false},
+		{"insert.throughput >= 2500/s", 2500, true},
+		{"insert.count > 1000", 1000, false},
+		{"read.p99 < 25ms", 0, false}, // no read operations
+	}
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.rule)
+		if err != nil {
+			t.Fatalf("%q: %v", test.rule, err)
+		}
+		result := threshold.Evaluate(r)
+		if result.Passed != test.passed || result.Actual != test.actual {
+			t.Errorf("%q: got passed %v actual %g, want passed %v actual %g", test.rule, result.Passed, result.Actual, test.passed, test.actual)
+		}
+	}
+
+	thresholds, err := ParseThresholds([]string{"insert.p99 < 25ms", "insert.errors < 5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, passed := EvaluateThresholds(r, thresholds); passed || len(results) != 2 {
+		t.Errorf("got passed %v with %d results, want a failure with 2 results", passed, len(results))
+	}
+}
+
+func TestThresholdContinuous(t *testing.T) {
+	tests := []struct {
+		rule       string
+		continuous bool
+		final      bool
+	}{
+		{"insert.p99 < 25ms", true, false},
+		{"insert.error_rate < 1%", true, false},
+		{"insert.errors < 10", true, true},
+		{"insert.errors > 10", false, false},
+		{"insert.throughput > 100", false, false},
+		{"insert.count >= 100", false, false},
+	}
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.rule)
+		if err != nil {
+			t.Fatalf("%q: %v", test.rule, err)
+		}
+		if got := threshold.Continuous(); got != test.continuous {
+			t.Errorf("%q: Continuous() = %v, want %v", test.rule, got, test.continuous)
+		}
+		if got := threshold.Final(); got != test.final {
+			t.Errorf("%q: Final() = %v, want %v", test.rule, got, test.final)
+		}
+	}
+}
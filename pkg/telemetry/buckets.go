@@ -0,0 +1,49 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package telemetry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultLatencyBuckets are the latency histogram buckets, in seconds, used
+// when none are configured: 0.5ms doubling up to ~16s.
+var DefaultLatencyBuckets = prometheus.ExponentialBuckets(0.0005, 2, 16)
+
+// ParseBuckets parses a comma separated list of histogram bucket upper
+// bounds, which must strictly increase.  An empty string returns the default
+// latency buckets.
+func ParseBuckets(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultLatencyBuckets, nil
+	}
+	buckets := []float64{}
+	for _, field := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %v", field, err)
+		}
+		if n := len(buckets); n > 0 && !(b > buckets[n-1]) {
+			return nil, fmt.Errorf("bucket %v does not follow %v; buckets must strictly increase", b, buckets[n-1])
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
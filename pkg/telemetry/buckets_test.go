@@ -0,0 +1,51 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package telemetry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []float64 // nil for an error
+	}{
+		{"", DefaultLatencyBuckets},
+		{"0.001, 0.01,0.1 ,1", []float64{0.001, 0.01, 0.1, 1}},
+		{"0.5", []float64{0.5}},
+		{"0.1,0.1", nil},
+		{"1,0.1", nil},
+		{"0.1,NaN", nil},
+		{"0.1,,1", nil},
+		{"fast", nil},
+	}
+	for _, test := range tests {
+		got, err := ParseBuckets(test.s)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.s, got, test.want)
+		}
+	}
+}
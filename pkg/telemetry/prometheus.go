@@ -39,7 +39,6 @@ type Prometheus struct {
 // PushMetrics will push metrics from the registry at Frequency
 func (p *Prometheus) PushMetrics(waitGroup *sync.WaitGroup, exit chan bool) {
 	defer waitGroup.Done()
-	p.Registry.MustRegister(prometheus.NewGoCollector())
 	hostname, _ := os.Hostname()
 	l := log.WithFields(log.Fields{
 		"server": p.Options.Server,
@@ -0,0 +1,128 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Readiness tracks the conditions that must be met before an instance is
+// ready to serve load.  The zero value is ready.
+type Readiness struct {
+	mu         sync.Mutex
+	conditions map[string]bool
+}
+
+// Require adds a condition that must be met before the instance is ready
+func (r *Readiness) Require(condition string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conditions == nil {
+		r.conditions = make(map[string]bool)
+	}
+	if _, ok := r.conditions[condition]; !ok {
+		r.conditions[condition] = false
+	}
+}
+
+// Met marks a condition as met
+func (r *Readiness) Met(condition string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conditions == nil {
+		r.conditions = make(map[string]bool)
+	}
+	r.conditions[condition] = true
+}
+
+// Pending returns the conditions that have not been met in sorted order
+func (r *Readiness) Pending() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := []string{}
+	for condition, met := range r.conditions {
+		if !met {
+			pending = append(pending, condition)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// Ready returns true once every required condition has been met
+func (r *Readiness) Ready() bool {
+	return len(r.Pending()) == 0
+}
+
+// Server serves the metrics of a registry for prometheus to scrape along with
+// liveness (/healthz) and readiness (/readyz) probes.
+type Server struct {
+	Listen    string
+	Registry  *prometheus.Registry
+	Readiness *Readiness
+	server    *http.Server
+}
+
+// Start starts serving in the background
+func (s *Server) Start() {
+	l := log.WithFields(log.Fields{
+		"listen": s.Listen,
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(s.Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if pending := s.Readiness.Pending(); len(pending) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "waiting for: %s\n", strings.Join(pending, ", "))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	s.server = &http.Server{
+		Addr:    s.Listen,
+		Handler: mux,
+	}
+
+	go func() {
+		l.Info("serving metrics")
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			l.WithField("error", err).Error("metrics server failed")
+		}
+	}()
+}
+
+// Shutdown stops the server once in flight requests have completed
+func (s *Server) Shutdown(ctx context.Context) {
+	if s.server == nil {
+		return
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.WithField("error", err).Error("could not shutdown the metrics server")
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Key distributions supported by ParseKeySelector
+const (
+	KeysFIFO    = "fifo"
+	KeysUniform = "uniform"
+	KeysZipfian = "zipfian"
+	KeysLatest  = "latest"
+	KeysHotspot = "hotspot"
+)
+
+// KeySelector picks the position of the next key to read from a pool of n
+// written keys, 0 being the oldest.  Selectors are safe for concurrent use.
+type KeySelector interface {
+	Next(rng *rand.Rand, n int) int
+	String() string
+}
+
+// ParseKeySelector parses a key distribution of the form name[:parameters]:
+//
+//	uniform                  every key is equally likely
+//	zipfian[:theta]          skewed towards the oldest keys; 0 < theta < 1, default 0.99
+//	latest:count             uniform over the most recently written count keys
+//	hotspot:fraction:share   share of the reads go to the oldest fraction of the keys
+//
+// fifo, the default, returns a nil selector: keys are consumed in the order
+// they were written.
+func ParseKeySelector(s string) (KeySelector, error) {
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	name, params := fields[0], fields[1:]
+	floats := make([]float64, len(params))
+	for i, p := range params {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter %q", name, p)
+		}
+		floats[i] = f
+	}
+	arity := func(min, max int) error {
+		if len(floats) < min || len(floats) > max {
+			return fmt.Errorf("%s takes %d to %d parameters, got %d", name, min, max, len(floats))
+		}
+		return nil
+	}
+
+	switch name {
+	case "", KeysFIFO:
+		return nil, arity(0, 0)
+	case KeysUniform:
+		return uniformKeys{}, arity(0, 0)
+	case KeysZipfian:
+		if err := arity(0, 1); err != nil {
+			return nil, err
+		}
+		theta := 0.99
+		if len(floats) == 1 {
+			theta = floats[0]
+		}
+		if theta <= 0 || theta >= 1 {
+			return nil, fmt.Errorf("zipfian theta must be between 0 and 1, got %g", theta)
+		}
+		return &zipfianKeys{theta: theta}, nil
+	case KeysLatest:
+		if err := arity(1, 1); err != nil {
+			return nil, err
+		}
+		if floats[0] < 1 {
+			return nil, fmt.Errorf("latest count must be at least 1, got %g", floats[0])
+		}
+		return latestKeys{count: int(floats[0])}, nil
+	case KeysHotspot:
+		if err := arity(2, 2); err != nil {
+			return nil, err
+		}
+		if floats[0] <= 0 || floats[0] >= 1 || floats[1] < 0 || floats[1] > 1 {
+			return nil, fmt.Errorf("hotspot fraction must be between 0 and 1 and share between 0 and 1")
+		}
+		return hotspotKeys{fraction: floats[0], share: floats[1]}, nil
+	}
+	return nil, fmt.Errorf("unknown key distribution %q", name)
+}
+
+type uniformKeys struct{}
+
+func (uniformKeys) Next(rng *rand.Rand, n int) int {
+	return rng.Intn(n)
+}
+
+func (uniformKeys) String() string {
+	return KeysUniform
+}
+
+type latestKeys struct {
+	count int
+}
+
+func (k latestKeys) Next(rng *rand.Rand, n int) int {
+	if n < k.count {
+		return rng.Intn(n)
+	}
+	return n - 1 - rng.Intn(k.count)
+}
+
+func (k latestKeys) String() string {
+	return fmt.Sprintf("%s:%d", KeysLatest, k.count)
+}
+
+type hotspotKeys struct {
+	fraction float64
+	share    float64
+}
+
+func (k hotspotKeys) Next(rng *rand.Rand, n int) int {
+	hot := int(float64(n) * k.fraction)
+	if hot < 1 {
+		hot = 1
+	}
+	if hot >= n || rng.Float64() < k.share {
+		return rng.Intn(hot)
+	}
+	return hot + rng.Intn(n-hot)
+}
+
+func (k hotspotKeys) String() string {
+	return fmt.Sprintf("%s:%g:%g", KeysHotspot, k.fraction, k.share)
+}
+
+// zipfianKeys implements the zipfian generator of Gray et al., "Quickly
+// Generating Billion-Record Synthetic Databases", as used by YCSB.  The zeta
+// constant is extended incrementally as keys are written.
+type zipfianKeys struct {
+	theta float64
+	mu    sync.Mutex
+	n     int
+	zetan float64
+}
+
+func (k *zipfianKeys) Next(rng *rand.Rand, n int) int {
+	if n < 2 {
+		return 0
+	}
+	zetan := k.zeta(n)
+	zeta2 := 1 + math.Pow(0.5, k.theta)
+	alpha := 1 / (1 - k.theta)
+	eta := (1 - math.Pow(2/float64(n), 1-k.theta)) / (1 - zeta2/zetan)
+
+	u := rng.Float64()
+	uz := u * zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < zeta2 {
+		return 1
+	}
+	i := int(float64(n) * math.Pow(eta*u-eta+1, alpha))
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+// zeta returns the sum of 1/i^theta for i in 1..n.  The sum follows the
+// size of the pool by adding or removing the terms it has gained or lost, so
+// a pool that grows and shrinks by a few keys at a time, as inserts and
+// deletes do, costs a few terms per call.
+func (k *zipfianKeys) zeta(n int) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i := k.n + 1; i <= n; i++ {
+		k.zetan += 1 / math.Pow(float64(i), k.theta)
+	}
+	for i := k.n; i > n; i-- {
+		k.zetan -= 1 / math.Pow(float64(i), k.theta)
+	}
+	k.n = n
+	return k.zetan
+}
+
+func (k *zipfianKeys) String() string {
+	return fmt.Sprintf("%s:%g", KeysZipfian, k.theta)
+}
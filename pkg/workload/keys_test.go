@@ -0,0 +1,138 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseKeySelector(t *testing.T) {
+	tests := []struct {
+		spec   string
+		want   string // String() of the selector; "" for fifo
+		failed bool
+	}{
+		{"", "", false},
+		{"fifo", "", false},
+		{"uniform", "uniform", false},
+		{"zipfian", "zipfian:0.99", false},
+		{"zipfian:0.5", "zipfian:0.5", false},
+		{"latest:100", "latest:100", false},
+		{"hotspot:0.2:0.8", "hotspot:0.2:0.8", false},
+		{"fifo:1", "", true},
+		{"uniform:1", "", true},
+		{"zipfian:1", "", true},
+		{"zipfian:0", "", true},
+		{"zipfian:x", "", true},
+		{"latest", "", true},
+		{"latest:0", "", true},
+		{"hotspot:0.2", "", true},
+		{"hotspot:1:0.5", "", true},
+		{"hotspot:0.2:1.5", "", true},
+		{"gaussian", "", true},
+	}
+	for _, test := range tests {
+		selector, err := ParseKeySelector(test.spec)
+		if test.failed {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		got := ""
+		if selector != nil {
+			got = selector.String()
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestKeySelectorBounds(t *testing.T) {
+	tests := []struct {
+		spec string
+		n    int
+		min  int // smallest position that may be returned
+	}{
+		{"uniform", 1, 0},
+		{"uniform", 1000, 0},
+		{"zipfian", 1, 0},
+		{"zipfian", 2, 0},
+		{"zipfian", 1000, 0},
+		{"zipfian:0.2", 1000, 0},
+		{"latest:10", 5, 0},
+		{"latest:10", 1000, 990},
+		{"hotspot:0.1:0.9", 1, 0},
+		{"hotspot:0.1:0.9", 1000, 0},
+	}
+	for _, test := range tests {
+		selector, err := ParseKeySelector(test.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", test.spec, err)
+		}
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 10000; i++ {
+			if k := selector.Next(rng, test.n); k < test.min || k >= test.n {
+				t.Fatalf("%s with %d keys: position %d is out of [%d, %d)", test.spec, test.n, k, test.min, test.n)
+			}
+		}
+	}
+}
+
+func TestKeySelectorSkew(t *testing.T) {
+	tests := []struct {
+		spec  string
+		hot   int     // positions below hot are the hot keys
+		share float64 // expected minimum share of reads on the hot keys
+	}{
+		{"zipfian", 10, 0.3},
+		{"hotspot:0.1:0.9", 100, 0.85},
+	}
+	const n, draws = 1000, 20000
+	for _, test := range tests {
+		selector, err := ParseKeySelector(test.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", test.spec, err)
+		}
+		rng := rand.New(rand.NewSource(1))
+		hot := 0
+		for i := 0; i < draws; i++ {
+			if selector.Next(rng, n) < test.hot {
+				hot++
+			}
+		}
+		if share := float64(hot) / draws; share < test.share {
+			t.Errorf("%s: %.2f of the reads went to the hot keys, want at least %.2f", test.spec, share, test.share)
+		}
+	}
+}
+
+func TestZipfianZetaFollowsPool(t *testing.T) {
+	k := &zipfianKeys{theta: 0.99}
+	want := (&zipfianKeys{theta: 0.99}).zeta(500)
+	for _, n := range []int{100, 1000, 499, 500} {
+		k.zeta(n)
+	}
+	if got := k.zeta(500); got < want-1e-9 || got > want+1e-9 {
+		t.Errorf("zeta after growing and shrinking the pool: got %g, want %g", got, want)
+	}
+}
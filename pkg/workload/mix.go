@@ -0,0 +1,124 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Weighted is a named item with a relative weight
+type Weighted struct {
+	Name   string
+	Weight int
+}
+
+// Mix selects named items at random in proportion to their weights
+type Mix struct {
+	items []Weighted
+	total int
+}
+
+// ParseMix parses a comma separated list of name:weight pairs into a Mix.
+//
+// Weights are relative to each other and do not need to add up to 100; a mix
+// of "read:70,insert:20,update:10" is the same as "read:7,insert:2,update:1".
+// An item without a weight is given a weight of one.
+func ParseMix(s string) (*Mix, error) {
+	m := Mix{}
+	seen := map[string]bool{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name := field
+		weight := 1
+		if i := strings.LastIndex(field, ":"); i >= 0 {
+			name = strings.TrimSpace(field[:i])
+			w, err := strconv.Atoi(strings.TrimSpace(field[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight for %q: %v", name, err)
+			}
+			weight = w
+		}
+		if name == "" {
+			return nil, fmt.Errorf("missing name in %q", field)
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("negative weight for %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%q is listed more than once", name)
+		}
+		seen[name] = true
+		if weight == 0 {
+			continue
+		}
+		m.items = append(m.items, Weighted{Name: name, Weight: weight})
+		m.total += weight
+	}
+	if m.total == 0 {
+		return nil, fmt.Errorf("mix %q has no items with a positive weight", s)
+	}
+	return &m, nil
+}
+
+// Pick returns the name of an item selected at random by weight
+func (m *Mix) Pick(r *rand.Rand) string {
+	n := r.Intn(m.total)
+	for _, item := range m.items {
+		if n < item.Weight {
+			return item.Name
+		}
+		n -= item.Weight
+	}
+	return m.items[len(m.items)-1].Name
+}
+
+// Items returns the weighted items of the mix in the order they were parsed
+func (m *Mix) Items() []Weighted {
+	return m.items
+}
+
+// Weight returns the weight of a named item or zero if it is not in the mix
+func (m *Mix) Weight(name string) int {
+	for _, item := range m.items {
+		if item.Name == name {
+			return item.Weight
+		}
+	}
+	return 0
+}
+
+// Fraction returns the share of the mix given to a named item
+func (m *Mix) Fraction(name string) float64 {
+	if m.total == 0 {
+		return 0
+	}
+	return float64(m.Weight(name)) / float64(m.total)
+}
+
+// String returns the mix in the name:weight form accepted by ParseMix
+func (m *Mix) String() string {
+	fields := make([]string, 0, len(m.items))
+	for _, item := range m.items {
+		fields = append(fields, fmt.Sprintf("%s:%d", item.Name, item.Weight))
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,79 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		spec   string
+		want   string
+		failed bool
+	}{
+		{"read", "read:1", false},
+		{"read:70,insert:20,update:10", "read:70,insert:20,update:10", false},
+		{" read : 2 , insert ", "read:2,insert:1", false},
+		{"read:1,insert:0", "read:1", false},
+		{"query.by_id:3", "query.by_id:3", false},
+		{"", "", true},
+		{"read:0", "", true},
+		{"read:-1", "", true},
+		{"read:x", "", true},
+		{":1", "", true},
+		{"read:1,read:2", "", true},
+		{"read:0,read:2", "", true},
+		{"read:2,read:0", "", true},
+		{"read:1,insert:0,insert:0", "", true},
+	}
+	for _, test := range tests {
+		mix, err := ParseMix(test.spec)
+		if test.failed {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if got := mix.String(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestMixPick(t *testing.T) {
+	mix, err := ParseMix("read:70,insert:20,update:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const draws = 100000
+	counts := map[string]int{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < draws; i++ {
+		counts[mix.Pick(rng)]++
+	}
+	for _, item := range mix.Items() {
+		got := float64(counts[item.Name]) / draws
+		if want := mix.Fraction(item.Name); got < want-0.01 || got > want+0.01 {
+			t.Errorf("%s: picked %.3f of the time, want %.3f", item.Name, got, want)
+		}
+	}
+}
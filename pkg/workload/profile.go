@@ -0,0 +1,162 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	profileStage = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "mdbload",
+			Name:      "profile_stage",
+			Help:      "index of the load profile stage currently running",
+		},
+	)
+)
+
+// Stage is a single phase of a load profile.
+//
+// A stage holds its target rate (ops/sec across all operations) or
+// concurrency (active workers) for its whole duration.  A ramped stage moves
+// linearly from the target of the previous stage, or zero for the first
+// stage, to its own target over its duration.
+type Stage struct {
+	Name        string        `mapstructure:"name"`
+	Duration    time.Duration `mapstructure:"duration"`
+	Rate        float64       `mapstructure:"rate"`
+	Concurrency int           `mapstructure:"concurrency"`
+	Ramp        bool          `mapstructure:"ramp"`
+}
+
+// Profile is an ordered list of stages driving the load of a test
+type Profile struct {
+	Stages   []Stage
+	Registry *prometheus.Registry
+	start    time.Time
+}
+
+// Init validates the profile and registers the profile metrics
+func (p *Profile) Init() error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("profile has no stages")
+	}
+	rate := false
+	concurrency := false
+	for i, stage := range p.Stages {
+		if stage.Duration <= 0 {
+			return fmt.Errorf("stage %d has no duration", i)
+		}
+		if stage.Rate < 0 || stage.Concurrency < 0 {
+			return fmt.Errorf("stage %d has a negative target", i)
+		}
+		rate = rate || stage.Rate > 0
+		concurrency = concurrency || stage.Concurrency > 0
+	}
+	if rate == concurrency {
+		return fmt.Errorf("profile stages must target either a rate or a concurrency")
+	}
+	p.Registry.MustRegister(profileStage)
+	return nil
+}
+
+// RateLimited returns true if the profile targets a rate, false if it targets
+// a concurrency
+func (p *Profile) RateLimited() bool {
+	for _, stage := range p.Stages {
+		if stage.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Duration returns the total duration of the profile
+func (p *Profile) Duration() time.Duration {
+	var d time.Duration
+	for _, stage := range p.Stages {
+		d += stage.Duration
+	}
+	return d
+}
+
+// MaxConcurrency returns the highest concurrency of any stage
+func (p *Profile) MaxConcurrency() int {
+	max := 0
+	for _, stage := range p.Stages {
+		if stage.Concurrency > max {
+			max = stage.Concurrency
+		}
+	}
+	return max
+}
+
+// Start marks the start of the profile and tracks the current stage until
+// the profile has finished.
+func (p *Profile) Start() {
+	p.start = time.Now()
+	go func() {
+		for i, stage := range p.Stages {
+			profileStage.Set(float64(i))
+			log.WithFields(log.Fields{
+				"stage":       i,
+				"name":        stage.Name,
+				"duration":    stage.Duration,
+				"rate":        stage.Rate,
+				"concurrency": stage.Concurrency,
+				"ramp":        stage.Ramp,
+			}).Info("starting profile stage")
+			time.Sleep(stage.Duration)
+		}
+	}()
+}
+
+// Elapsed returns the time since the profile was started
+func (p *Profile) Elapsed() time.Duration {
+	return time.Since(p.start)
+}
+
+// Rate returns the target rate at a point in the profile
+func (p *Profile) Rate(elapsed time.Duration) float64 {
+	return p.target(elapsed, func(s Stage) float64 { return s.Rate })
+}
+
+// Concurrency returns the target concurrency at a point in the profile
+func (p *Profile) Concurrency(elapsed time.Duration) int {
+	return int(p.target(elapsed, func(s Stage) float64 { return float64(s.Concurrency) }) + 0.5)
+}
+
+// target interpolates the value of a stage target at a point in the profile
+func (p *Profile) target(elapsed time.Duration, value func(Stage) float64) float64 {
+	previous := 0.0
+	for _, stage := range p.Stages {
+		if elapsed < stage.Duration {
+			if !stage.Ramp {
+				return value(stage)
+			}
+			progress := float64(elapsed) / float64(stage.Duration)
+			return previous + (value(stage)-previous)*progress
+		}
+		elapsed -= stage.Duration
+		previous = value(stage)
+	}
+	return previous
+}
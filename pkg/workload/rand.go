@@ -0,0 +1,43 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// NewRand returns the random source of one stream of a seeded run, e.g. a
+// worker or a renderer.  The same seed and stream always produce the same
+// sequence; a seed of 0 returns a time seeded source.
+func NewRand(seed int64, stream int) *rand.Rand {
+	if seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano() + int64(stream)))
+	}
+	// spread the streams so neighbouring seeds do not share sequences
+	return rand.New(rand.NewSource(seed*1000003 + int64(stream)))
+}
+
+// NewUUID returns a version 4 UUID drawn from rng
+func NewUUID(rng *rand.Rand) uuid.UUID {
+	var id uuid.UUID
+	rng.Read(id[:])
+	id.SetVersion(uuid.V4)
+	id.SetVariant(uuid.VariantRFC4122)
+	return id
+}
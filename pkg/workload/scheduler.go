@@ -0,0 +1,206 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Arrival distributions supported by the Scheduler
+const (
+	ArrivalConstant = "constant"
+	ArrivalPoisson  = "poisson"
+)
+
+var (
+	scheduleLag = prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Namespace: "mdbload",
+			Name:      "schedule_lag_seconds",
+			Help:      "How far behind its intended start time an operation was picked up by a worker",
+		},
+	)
+
+	scheduleDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mdbload",
+			Name:      "schedule_dropped_total",
+			Help:      "Operations that were due before the scheduler stopped but never picked up by a worker",
+		},
+		[]string{"operation"},
+	)
+)
+
+// Ticket is a request to perform an operation at an intended time
+type Ticket struct {
+	Operation string
+	Intended  time.Time
+}
+
+// Scheduler issues tickets for each operation at a target rate, independent
+// of how quickly the tickets are processed (open loop).
+//
+// Each operation is scheduled by its own goroutine.  The intended time of a
+// ticket is advanced by the schedule alone so tickets a worker picks up late
+// keep their original intended time; measuring latency from the intended time
+// corrects for coordinated omission.
+//
+// Without a profile Rates holds the ops per second of each operation.  With a
+// profile the rate of the current stage is shared between the operations in
+// proportion to their weight in Rates.
+type Scheduler struct {
+	Rates    *Mix   // operation:ops per second, or operation:weight with a profile
+	Arrival  string // constant or poisson
+	Profile  *Profile
+	Seed     int64 // makes the arrivals reproducible; 0 for random arrivals
+	Registry *prometheus.Registry
+	tickets  chan Ticket
+	dropped  int64
+}
+
+// the longest the schedule is advanced before the rate is looked at again
+const maxScheduleStep = 100 * time.Millisecond
+
+// Init validates the scheduler options and registers the scheduler metrics
+func (s *Scheduler) Init() error {
+	switch s.Arrival {
+	case ArrivalConstant, ArrivalPoisson:
+	default:
+		return fmt.Errorf("unknown arrival distribution %q", s.Arrival)
+	}
+	s.tickets = make(chan Ticket)
+	s.Registry.MustRegister(scheduleLag)
+	s.Registry.MustRegister(scheduleDropped)
+	return nil
+}
+
+// Start issues tickets until the duration has expired or ctx is cancelled.
+// The ticket channel is closed once every operation has stopped.
+func (s *Scheduler) Start(ctx context.Context, duration time.Duration) {
+	wg := new(sync.WaitGroup)
+	stop := make(chan struct{})
+	start := time.Now()
+	for i, item := range s.Rates.Items() {
+		wg.Add(1)
+		go s.schedule(item.Name, start, start.Add(duration), stop, i, wg)
+	}
+	go func() {
+		select {
+		case <-time.After(duration):
+		case <-ctx.Done():
+		}
+		close(stop)
+		wg.Wait()
+		close(s.tickets)
+	}()
+}
+
+// Next blocks until a ticket is issued.  false is returned once the
+// scheduler has stopped.
+func (s *Scheduler) Next() (Ticket, bool) {
+	t, ok := <-s.tickets
+	if ok {
+		scheduleLag.Observe(time.Since(t.Intended).Seconds())
+	}
+	return t, ok
+}
+
+// Dropped returns the number of tickets that were due when the scheduler
+// stopped but had not been picked up, because the workers fell behind.
+func (s *Scheduler) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *Scheduler) schedule(operation string, next time.Time, end time.Time, stop chan struct{}, stream int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	start := next
+	rng := NewRand(s.Seed, stream)
+	timer := time.NewTimer(maxScheduleStep)
+	timer.Stop()
+	l := log.WithFields(log.Fields{
+		"operation": operation,
+		"arrival":   s.Arrival,
+	})
+	l.Info("scheduling operations")
+	for {
+		next = s.advance(operation, start, next, end, rng)
+		if d := time.Until(next); d > 0 {
+			timer.Reset(d)
+			select {
+			case <-timer.C:
+			case <-stop:
+				l.Debug("scheduler stopped")
+				return
+			}
+		}
+		select {
+		case s.tickets <- Ticket{Operation: operation, Intended: next}:
+		case <-stop:
+			// the pending ticket and every later one already due are lost
+			dropped := int64(1)
+			now := time.Now()
+			for next = s.advance(operation, start, next, now, rng); !next.After(now); next = s.advance(operation, start, next, now, rng) {
+				dropped++
+			}
+			atomic.AddInt64(&s.dropped, dropped)
+			scheduleDropped.WithLabelValues(operation).Add(float64(dropped))
+			l.WithField("dropped", dropped).Debug("scheduler stopped")
+			return
+		}
+	}
+}
+
+// advance the schedule from next until the expected number of arrivals at
+// the current rate reaches the next arrival.  The schedule is not advanced
+// past until, so a rate of zero cannot keep it going forever.
+func (s *Scheduler) advance(operation string, start time.Time, next time.Time, until time.Time, rng *rand.Rand) time.Time {
+	for need := s.arrival(rng); need > 0 && !next.After(until); {
+		rate := s.rate(operation, next.Sub(start))
+		step := maxScheduleStep.Seconds()
+		if rate > 0 && need/rate < step {
+			step = need / rate
+		}
+		next = next.Add(time.Duration(step * float64(time.Second)))
+		need -= rate * step
+	}
+	return next
+}
+
+// rate returns the ops per second of an operation at a point in the test
+func (s *Scheduler) rate(operation string, elapsed time.Duration) float64 {
+	if s.Profile != nil {
+		return s.Profile.Rate(elapsed) * s.Rates.Fraction(operation)
+	}
+	return float64(s.Rates.Weight(operation))
+}
+
+// arrival returns the number of expected operations until the next arrival;
+// exactly one for constant arrivals or exponentially distributed for a
+// poisson process.
+func (s *Scheduler) arrival(rng *rand.Rand) float64 {
+	if s.Arrival == ArrivalPoisson {
+		return rng.ExpFloat64()
+	}
+	return 1
+}
@@ -0,0 +1,49 @@
+// Copyright © 2019 Stephen Bunn
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package workload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSchedulerDropped(t *testing.T) {
+	rates, err := ParseMix("read:1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Scheduler{Rates: rates, Arrival: ArrivalConstant, Seed: 1, Registry: prometheus.NewRegistry()}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background(), 200*time.Millisecond)
+
+	// pick up a single ticket and fall behind until the test is over
+	if _, ok := s.Next(); !ok {
+		t.Fatal("the scheduler issued no ticket")
+	}
+	time.Sleep(300 * time.Millisecond)
+	for _, ok := s.Next(); ok; _, ok = s.Next() {
+		t.Error("a ticket was issued after the scheduler stopped")
+	}
+	// roughly 200 tickets were due before it stopped
+	if got := s.Dropped(); got < 100 || got > 250 {
+		t.Errorf("dropped %d tickets, want about 200", got)
+	}
+}